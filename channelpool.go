@@ -0,0 +1,137 @@
+package bunnyhop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// channelPool cho mượn tối đa max channel mở trên connection hiện tại của
+// client. Channel trả về được giữ lại để dùng tiếp, channel đã đóng bị bỏ và
+// được thay bằng channel mới ở lần mượn sau.
+type channelPool struct {
+	slots chan struct{} // Mỗi channel đang được mượn giữ một slot
+
+	mutex      sync.Mutex
+	idle       []amqpChannel
+	generation uint64 // Tăng mỗi khi reset, channel của generation cũ không được nhận lại
+}
+
+// newChannelPool tạo pool cho mượn tối đa max channel cùng lúc
+func newChannelPool(max int) *channelPool {
+	return &channelPool{slots: make(chan struct{}, max)}
+}
+
+// acquire mượn một channel, chờ nếu đã đủ max channel đang được mượn. open
+// được gọi để mở channel mới khi không còn channel rảnh
+func (p *channelPool) acquire(ctx context.Context, open func() (amqpChannel, error)) (amqpChannel, func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	p.mutex.Lock()
+	generation := p.generation
+	var ch amqpChannel
+	for len(p.idle) > 0 && ch == nil {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !last.IsClosed() {
+			ch = last
+		}
+	}
+	p.mutex.Unlock()
+
+	if ch == nil {
+		var err error
+		if ch, err = open(); err != nil {
+			<-p.slots
+			return nil, nil, err
+		}
+	}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { p.release(ch, generation) })
+	}, nil
+}
+
+// release nhận lại channel nếu nó còn mở và thuộc connection hiện tại
+func (p *channelPool) release(ch amqpChannel, generation uint64) {
+	p.mutex.Lock()
+	if generation == p.generation && !ch.IsClosed() {
+		p.idle = append(p.idle, ch)
+	} else if !ch.IsClosed() {
+		ch.Close()
+	}
+	p.mutex.Unlock()
+	<-p.slots
+}
+
+// reset đóng các channel rảnh, gọi khi connection bị đóng hoặc thay mới
+func (p *channelPool) reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, ch := range p.idle {
+		ch.Close()
+	}
+	p.idle = nil
+	p.generation++
+}
+
+// AcquireChannel mượn một channel riêng từ channel pool của client (cần
+// MaxChannels > 0), chờ đến khi có channel rảnh hoặc ctx hết hạn. Gọi release
+// khi dùng xong, không đóng channel. Channel đã bị đóng không được trả lại pool.
+// Khi MaxChannels là 0, channel dùng chung được trả về và release không làm gì.
+func (c *Client) AcquireChannel(ctx context.Context) (*amqp.Channel, func(), error) {
+	ch, release, err := c.lendChannel(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := asAMQPChannel(ch)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return raw, release, nil
+}
+
+// lendChannel mượn channel từ channel pool, hoặc trả về channel dùng chung
+// (mở lại nếu đã đóng) khi client không có channel pool
+func (c *Client) lendChannel(ctx context.Context) (amqpChannel, func(), error) {
+	if c.channels == nil {
+		ch, err := c.usableChannel()
+		return ch, func() {}, err
+	}
+	ctx, cancel := c.withClientContext(ctx)
+	defer cancel()
+	return c.channels.acquire(ctx, c.openPooledChannel)
+}
+
+// openPooledChannel mở channel mới cho channel pool trên connection hiện tại
+func (c *Client) openPooledChannel() (amqpChannel, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return nil, fmt.Errorf("client is not connected")
+	}
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+	go c.watchReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+	c.logger().Debug("Opened pooled channel")
+	return ch, nil
+}
+
+// closePooledChannels đóng các channel rảnh của channel pool, channel đang được
+// mượn bị đóng khi trả lại
+func (c *Client) closePooledChannels() {
+	if c.channels != nil {
+		c.channels.reset()
+	}
+}
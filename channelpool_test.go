@@ -0,0 +1,102 @@
+package bunnyhop
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ChannelPoolConcurrentPublish(t *testing.T) {
+	client, dialer := newFakeClient(Config{MaxChannels: 4})
+	require.NoError(t, client.ConnectOnce(context.Background()))
+	defer client.Close()
+	conn := dialer.last()
+
+	publishAll := func() []error {
+		var wg sync.WaitGroup
+		errs := make(chan error, 20*25)
+		for g := 0; g < 20; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					if err := client.PublishMessage("ex", "key", false, false, amqp.Publishing{}); err != nil {
+						errs <- err
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		var all []error
+		for err := range errs {
+			all = append(all, err)
+		}
+		return all
+	}
+
+	pooled := func() []*fakeChannel {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return append([]*fakeChannel(nil), conn.channels[1:]...)
+	}
+	published := func() int {
+		n := 0
+		for _, ch := range pooled() {
+			ch.mu.Lock()
+			n += len(ch.published)
+			ch.mu.Unlock()
+		}
+		return n
+	}
+
+	assert.Empty(t, publishAll())
+	assert.Equal(t, 500, published())
+	assert.LessOrEqual(t, len(pooled()), 4)
+	assert.Empty(t, conn.channel(0).published, "shared channel must not be used for publishing")
+
+	// Broker đóng các channel rảnh: chúng bị bỏ và được thay bằng channel mới
+	closed := pooled()
+	for _, ch := range closed {
+		ch.Close()
+	}
+	assert.Empty(t, publishAll())
+	assert.Equal(t, 1000, published())
+	assert.LessOrEqual(t, len(pooled()), len(closed)+4)
+	assert.Greater(t, len(pooled()), len(closed))
+}
+
+func TestClient_AcquireChannelBlocksAtMaxChannels(t *testing.T) {
+	client, _ := newFakeClient(Config{MaxChannels: 1})
+	require.NoError(t, client.ConnectOnce(context.Background()))
+	defer client.Close()
+
+	ch, release, err := client.lendChannel(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = client.lendChannel(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release() // Gọi lại không trả thêm slot
+	again, releaseAgain, err := client.lendChannel(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, ch, again, "released channel should be reused")
+	releaseAgain()
+
+	// Channel mượn trước reconnect không được nhận lại sau reconnect
+	stale, releaseStale, err := client.lendChannel(context.Background())
+	require.NoError(t, err)
+	client.mutex.Lock()
+	client.closePooledChannels()
+	client.mutex.Unlock()
+	releaseStale()
+	assert.True(t, stale.IsClosed())
+}
@@ -0,0 +1,146 @@
+package bunnyhop
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// streamOffsetHeader header chứa offset của message khi consume từ stream queue
+const streamOffsetHeader = "x-stream-offset"
+
+// defaultCheckpointInterval khoảng lưu checkpoint mặc định
+const defaultCheckpointInterval = 5 * time.Second
+
+// Checkpointer lưu offset đã xử lý của consumer trên stream queue để consume
+// lại từ offset tiếp theo sau khi restart hoặc subscribe lại
+type Checkpointer interface {
+	// LoadOffset trả về offset đã lưu, ok là false nếu chưa có checkpoint
+	LoadOffset(queue, consumerTag string) (offset int64, ok bool, err error)
+	// StoreOffset lưu offset đã xử lý gần nhất
+	StoreOffset(queue, consumerTag string, offset int64) error
+}
+
+// MemoryCheckpointer lưu checkpoint trong bộ nhớ, dùng cho test
+type MemoryCheckpointer struct {
+	mutex   sync.Mutex
+	offsets map[string]int64
+}
+
+// NewMemoryCheckpointer tạo checkpointer trong bộ nhớ
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{offsets: make(map[string]int64)}
+}
+
+// LoadOffset trả về offset đã lưu của consumer trên queue
+func (m *MemoryCheckpointer) LoadOffset(queue, consumerTag string) (int64, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	offset, ok := m.offsets[queue+"/"+consumerTag]
+	return offset, ok, nil
+}
+
+// StoreOffset lưu offset của consumer trên queue
+func (m *MemoryCheckpointer) StoreOffset(queue, consumerTag string, offset int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.offsets[queue+"/"+consumerTag] = offset
+	return nil
+}
+
+// checkpoint theo dõi offset đã xử lý của một consumer
+type checkpoint struct {
+	mutex     sync.Mutex
+	offset    int64 // Offset lớn nhất đã xử lý xong
+	processed bool  // Đã xử lý ít nhất một delivery có offset
+	stored    int64 // Offset đã lưu gần nhất
+	hasStored bool
+}
+
+// resumeArgs trả về bản sao args của basic.consume với x-stream-offset tiếp
+// theo checkpoint đã lưu, hoặc args gốc nếu chưa có checkpoint
+func resumeArgs(checkpointer Checkpointer, queue, consumerTag string, args amqp.Table) (amqp.Table, error) {
+	offset, ok, err := checkpointer.LoadOffset(queue, consumerTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %v", err)
+	}
+	if !ok {
+		return args, nil
+	}
+
+	resumed := make(amqp.Table, len(args)+1)
+	for k, v := range args {
+		resumed[k] = v
+	}
+	resumed[streamOffsetHeader] = offset + 1
+	return resumed, nil
+}
+
+// streamOffset đọc offset của delivery từ header x-stream-offset
+func streamOffset(d amqp.Delivery) (int64, bool) {
+	switch v := d.Headers[streamOffsetHeader].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// record ghi nhận delivery đã xử lý xong
+func (cp *checkpoint) record(d amqp.Delivery) {
+	offset, ok := streamOffset(d)
+	if !ok {
+		return
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if !cp.processed || offset > cp.offset {
+		cp.offset = offset
+		cp.processed = true
+	}
+}
+
+// storeCheckpoint lưu offset nếu đã thay đổi kể từ lần lưu trước
+func (cons *consumer) storeCheckpoint() {
+	cp := cons.checkpoint
+	cp.mutex.Lock()
+	offset, changed := cp.offset, cp.processed && (!cp.hasStored || cp.offset != cp.stored)
+	cp.mutex.Unlock()
+	if !changed {
+		return
+	}
+
+	if err := cons.opts.Checkpointer.StoreOffset(cons.queue, cons.tag, offset); err != nil {
+		cons.logger.Error("Failed to store checkpoint of consumer %s: %v", cons.tag, err)
+		return
+	}
+
+	cp.mutex.Lock()
+	cp.stored = offset
+	cp.hasStored = true
+	cp.mutex.Unlock()
+}
+
+// runCheckpoints lưu checkpoint định kỳ và lần cuối khi các worker của lần
+// subscribe đã dừng (done bị đóng)
+func (cons *consumer) runCheckpoints(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cons.storeCheckpoint()
+		case <-done:
+			cons.storeCheckpoint()
+			return
+		}
+	}
+}
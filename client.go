@@ -2,7 +2,12 @@ package bunnyhop
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand"
+	neturl "net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,145 +16,446 @@ import (
 
 // Config cấu hình cho Client
 type Config struct {
-	URLs                []string      // Danh sách URLs của RabbitMQ
-	ReconnectInterval   time.Duration // Thời gian chờ giữa các lần reconnect
-	MaxReconnectAttempt int           // Số lần thử reconnect tối đa
-	DebugLog            bool          // Bật/tắt debug log
-	Logger              Logger        // Custom logger interface
+	URLs                   []string              // Danh sách URLs của RabbitMQ
+	ReconnectInterval      time.Duration         // Thời gian chờ giữa các lần reconnect
+	MinReconnectInterval   time.Duration         // Khoảng chờ reconnect tối thiểu, mặc định 100ms
+	MaxReconnectAttempt    int                   // Số lần thử reconnect tối đa
+	DebugLog               bool                  // Bật/tắt debug log
+	Logger                 Logger                // Custom logger interface
+	QoSProfiles            map[string]QoSProfile // Các QoS profile đặt tên, mỗi profile dùng channel riêng
+	DefaultHeaders         amqp.Table            // Headers gắn vào mọi message publish (không ghi đè header của caller)
+	FailedPublishSink      FailedPublishSink     // Nơi ghi lại message bị nack, return hoặc publish lỗi
+	OnChannelClose         func(*amqp.Error)     // Callback khi một channel (không phải connection) bị đóng bất thường
+	GenerateMessageID      bool                  // PublishWithIDs tự sinh UUID làm message id khi để trống
+	QoSFailurePolicy       QoSFailurePolicy      // Cách xử lý khi thiết lập QoS thất bại (mặc định: QoSFailureFail)
+	DrainTimeout           time.Duration         // Thời gian chờ handler của consumer xử lý xong khi Close, hết hạn thì force-cancel, mặc định 30s
+	AutoDeclareExchange    *ExchangeAutoDeclare  // Nếu khác nil, exchange đích được tự khai báo trước lần publish đầu tiên
+	ShowCredentials        bool                  // Không ẩn credentials của URL trong log
+	ReconnectLogInterval   time.Duration         // Khoảng tối thiểu giữa các lần log lặp lại cùng một lỗi reconnect, mặc định 1 phút
+	AllowUnlimitedPrefetch bool                  // Không cảnh báo khi QoS có prefetch 0 (không giới hạn)
+	LatencyRecorder        LatencyRecorder       // Nhận latency của các operation (ví dụ confirm)
+	DryRun                 bool                  // Publish chỉ kiểm tra và cập nhật thống kê, không gửi đến broker
+	Purpose                ConnectionPurpose     // Mục đích của connection, mặc định PurposeBoth
+	DisableManagedChannel  bool                  // Không mở channel dùng chung khi connect, GetChannel mở channel (không QoS) khi cần
+	Keepalive              time.Duration         // Khoảng giữa các lần probe keepalive trên channel dùng chung, 0 là tắt
+	Codec                  Codec                 // Codec mã hóa/giải mã body, mặc định JSONCodec
+	TLSConfig              *tls.Config           // Cấu hình TLS (CA, client cert) cho URL amqps://, bị bỏ qua với URL amqp://
+	Heartbeat              time.Duration         // Heartbeat yêu cầu broker, 0 là mặc định của amqp091 (10s); query param heartbeat của URL được ưu tiên
+	DialTimeout            time.Duration         // Timeout của TCP dial và handshake, 0 là mặc định của amqp091 (30s); query param connection_timeout được ưu tiên
+	DisableTopologyReplay  bool                  // Không khai báo lại exchange, queue, binding đã ghi lại sau khi reconnect
+	PrefetchCount          int                   // Prefetch count của channel dùng chung, mặc định 1
+	PrefetchSize           int                   // Prefetch size (bytes) của channel dùng chung, 0 là không giới hạn
+	GlobalQos              bool                  // Áp dụng QoS của channel dùng chung cho toàn connection
+	Username               string                // Nếu khác rỗng, thay username trong URL khi dial, URL trong log không chứa credentials
+	Password               string                // Nếu khác rỗng, thay password trong URL khi dial
+	Vhost                  string                // Nếu khác rỗng, thay vhost trong URL khi dial
+	Backoff                *BackoffConfig        // Exponential backoff giữa các lần reconnect, nil là chờ cố định ReconnectInterval
+	MaxChannels            int                   // Số channel tối đa của channel pool dùng cho publish và WithChannel, 0 là dùng channel dùng chung
+
+	onCloseError func(*amqp.Error) // Nhận lỗi đóng connection/channel từ broker, dùng cho thống kê của pool
+	dial         dialFunc          // Hàm dial, thay thế được trong test
 }
 
 // Client quản lý kết nối đến RabbitMQ
 type Client struct {
 	config            Config
-	connection        *amqp.Connection
-	channel           *amqp.Channel
+	connection        amqpConnection
+	channel           amqpChannel
+	profileChannels   map[string]amqpChannel
+	declaredExchanges map[string]bool   // Exchange đã tự khai báo trên connection hiện tại
+	channels          *channelPool      // Channel pool khi MaxChannels > 0, nil là dùng channel dùng chung
+	confirms          *confirmTracker   // Confirm channel dùng chung, nil nếu chưa mở
+	confirmLatency    ewma              // Latency từ publish đến ack
+	returnMutex       sync.Mutex        // Bảo vệ returnHandler, tách khỏi mutex để không chặn việc đọc return
+	returnHandler     func(amqp.Return) // Nhận message bị broker return, đặt qua SetReturnHandler
+	consumers         map[string]*consumer
+	heartbeat         time.Duration // Heartbeat đã thỏa thuận với broker
+	topology          topologyRegistry
 	mutex             sync.RWMutex
 	connected         bool
 	reconnectAttempts int
 	ctx               context.Context
 	cancel            context.CancelFunc
 	reconnectTicker   *time.Ticker
-	connectionErrors  chan *amqp.Error
-	channelErrors     chan *amqp.Error
+	closeWatches      chan closeWatch // Close notification của connection mới nhất, chuyển cho reconnectWorker
+	workerStarted     bool            // reconnectWorker đã được chạy, chỉ một worker cho mỗi client
 	reconnecting      bool
+	reconnectLog      logThrottle
+	qos               *QoSProfile // QoS của channel dùng chung đặt qua SetQos, nil là mặc định
+	keepaliveStarted  bool        // keepaliveWorker đã được chạy
+	configErr         error       // Lỗi cấu hình phát hiện trong NewClient, trả về khi connect
 }
 
-// NewClient tạo client mới
+// NewClient tạo client mới. URL rỗng, sai định dạng hoặc bị trùng bị bỏ qua,
+// các lỗi khác của config.Validate chỉ được log; riêng prefetch, heartbeat,
+// dial timeout và max channels âm làm Connect trả lỗi như trước
 func NewClient(config Config) *Client {
+	urls, urlErrs := usableURLs(config.URLs)
+	config.URLs = urls
+	validateErr := config.Validate()
+	configErr := config.connectError()
+
+	client := newClient(config, configErr)
+	for _, err := range urlErrs {
+		client.logger().Warn("Ignoring URL: %v", err)
+	}
+	if configErr != nil {
+		client.logger().Error("%v", configErr)
+	} else if validateErr != nil {
+		client.logger().Warn("Invalid client config: %v", validateErr)
+	}
+	return client
+}
+
+// NewClientWithError tạo client mới, trả về lỗi nếu config không hợp lệ (xem
+// Config.Validate) hoặc không có URL nào thay vì bỏ qua và chỉ log
+func NewClientWithError(config Config) (*Client, error) {
+	if len(config.URLs) == 0 {
+		return nil, fmt.Errorf("invalid client config: at least one URL is required")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client config: %w", err)
+	}
+	return newClient(config, nil), nil
+}
+
+// newClient tạo client từ config đã được kiểm tra, configErr được trả về khi connect
+func newClient(config Config, configErr error) *Client {
 	if config.ReconnectInterval == 0 {
 		config.ReconnectInterval = 30 * time.Second
 	}
+	if config.MinReconnectInterval <= 0 {
+		config.MinReconnectInterval = defaultMinReconnectInterval
+	}
+	if config.ReconnectLogInterval == 0 {
+		config.ReconnectLogInterval = defaultReconnectLogInterval
+	}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
 	if config.Logger == nil {
 		config.Logger = NewDefaultLogger(config.DebugLog)
 	}
+	if config.dial == nil {
+		config.dial = defaultDial
+	}
+	if config.FailedPublishSink == nil {
+		config.FailedPublishSink = noopFailedPublishSink{}
+	}
+	if config.Codec == nil {
+		config.Codec = JSONCodec{}
+	}
+	if config.PrefetchCount == 0 {
+		config.PrefetchCount = 1
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Client{
-		config:           config,
-		ctx:              ctx,
-		cancel:           cancel,
-		connectionErrors: make(chan *amqp.Error, 1),
-		channelErrors:    make(chan *amqp.Error, 1),
-		reconnecting:     false,
+	client := &Client{
+		config:       config,
+		configErr:    configErr,
+		ctx:          ctx,
+		cancel:       cancel,
+		closeWatches: make(chan closeWatch, 1),
+		consumers:    make(map[string]*consumer),
+		reconnecting: false,
+	}
+	if config.MaxChannels > 0 {
+		client.channels = newChannelPool(config.MaxChannels)
 	}
+	return client
 }
 
 // Connect thiết lập kết nối đến RabbitMQ
 func (c *Client) Connect(ctx context.Context) error {
+	return c.connect(ctx, true)
+}
+
+// ConnectOnce thiết lập kết nối mà không chạy goroutine nền (reconnect worker,
+// theo dõi return, confirm channel mở sẵn). Connection không tự reconnect khi
+// bị mất, chỉ dùng cho client ngắn hạn như benchmark.
+func (c *Client) ConnectOnce(ctx context.Context) error {
+	return c.connect(ctx, false)
+}
+
+// connect kết nối đến URL đầu tiên thành công, background quyết định có chạy
+// các goroutine nền hay không
+func (c *Client) connect(ctx context.Context, background bool) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.configErr != nil {
+		return c.configErr
+	}
 	if c.connected {
 		return nil
 	}
 
+	if len(c.config.URLs) == 0 {
+		return fmt.Errorf("no valid RabbitMQ URL configured")
+	}
+
 	c.logger().Debug("Connecting to RabbitMQ...")
 
 	// Thử kết nối đến từng URL
 	var lastErr error
 	for _, url := range c.config.URLs {
-		if err := c.connectToURL(url); err != nil {
+		if err := c.connectToURL(url, background); err != nil {
 			lastErr = err
-			c.logger().Warn("Failed to connect to %s: %v", url, err)
+			c.logger().Debug("Failed to connect to %s: %v", c.redact(url), err)
 			continue
 		}
-		c.logger().Info("Successfully connected to %s", url)
+		c.reconnectLog.reset()
+		c.logger().Info("Successfully connected to %s", c.redact(url))
+		if background && c.config.Keepalive > 0 && !c.keepaliveStarted {
+			c.keepaliveStarted = true
+			go c.keepaliveWorker(c.config.Keepalive)
+		}
 		return nil
 	}
 
-	return fmt.Errorf("failed to connect to any RabbitMQ server: %v", lastErr)
+	return fmt.Errorf("failed to connect to any RabbitMQ server: %w", lastErr)
 }
 
 // connectToURL kết nối đến một URL cụ thể
-func (c *Client) connectToURL(url string) error {
-	// Tạo connection
-	conn, err := amqp.Dial(url)
-	if err != nil {
-		return fmt.Errorf("failed to dial: %v", err)
+func (c *Client) connectToURL(url string, background bool) error {
+	secure := isTLSURL(url)
+	if c.config.TLSConfig != nil && !secure {
+		c.logger().Debug("Ignoring TLSConfig for non-TLS URL %s", c.redact(url))
 	}
 
-	// Tạo channel
-	ch, err := conn.Channel()
+	dialURL, err := c.dialURL(url)
 	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to open channel: %v", err)
+		return err
 	}
 
-	// Thiết lập QoS
-	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
+	// Tạo connection
+	conn, err := c.config.dial(dialURL, c.dialConfig(url))
 	if err != nil {
-		ch.Close()
-		conn.Close()
-		return fmt.Errorf("failed to set QoS: %v", err)
+		var parseErr *neturl.Error
+		if errors.As(err, &parseErr) {
+			return fmt.Errorf("failed to dial: %v", urlError(url, err))
+		}
+		var verifyErr *tls.CertificateVerificationError
+		if secure && c.config.TLSConfig == nil && errors.As(err, &verifyErr) {
+			return fmt.Errorf("failed to dial: %w (set TLSConfig to trust the broker certificate)", err)
+		}
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+
+	// Broker có thể thay heartbeat yêu cầu bằng giá trị của nó trong connection.tune
+	requested := defaultHeartbeat
+	if c.config.Heartbeat > 0 {
+		requested = c.config.Heartbeat
+	}
+	if opts, err := parseURLOptions(url); err == nil && opts.Heartbeat > 0 {
+		requested = opts.Heartbeat
+	}
+	heartbeat := conn.NegotiatedConfig().Heartbeat
+	if heartbeat != requested {
+		c.logger().Warn("Broker overrode requested heartbeat %v with %v for %s", requested, heartbeat, c.redact(url))
+	}
+
+	// Tạo channel dùng chung, trừ khi caller tự quản lý channel
+	var ch amqpChannel
+	if !c.config.DisableManagedChannel {
+		ch, err = conn.Channel()
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to open channel: %v", err)
+		}
+
+		// Thiết lập QoS
+		ch, err = c.setupMainChannel(conn, ch)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to set QoS: %v", err)
+		}
 	}
 
 	// Lưu connection và channel
 	c.connection = conn
 	c.channel = ch
+	c.heartbeat = heartbeat
+	c.profileChannels = make(map[string]amqpChannel)
+	c.declaredExchanges = make(map[string]bool)
 	c.connected = true
 	c.reconnectAttempts = 0
 	c.reconnecting = false
 
+	if !background {
+		return nil
+	}
+
+	// Publisher mở sẵn confirm channel để lần publish đầu không phải chờ
+	if c.config.Purpose == PurposePublisher && !c.config.DisableManagedChannel {
+		if _, err := c.confirmChannel(); err != nil {
+			c.logger().Warn("Failed to open confirm channel for %s: %v", c.redact(url), err)
+		}
+	}
+
 	// Thiết lập error handlers
 	c.setupErrorHandlers()
 
-	// Bắt đầu reconnect goroutine
-	go c.reconnectWorker()
+	// Một reconnect worker cho cả vòng đời client, các connection sau chỉ
+	// chuyển close notification mới cho worker
+	if !c.workerStarted {
+		c.workerStarted = true
+		go c.reconnectWorker()
+	}
+
+	return nil
+}
+
+// dialURL trả về URL để dial: url với Username, Password và Vhost của Config
+// nếu được đặt. Chỉ dùng khi dial, log luôn dùng url gốc
+func (c *Client) dialURL(url string) (string, error) {
+	if c.config.Username == "" && c.config.Password == "" && c.config.Vhost == "" {
+		return url, nil
+	}
+	return withCredentials(url, c.config.Username, c.config.Password, c.config.Vhost)
+}
+
+// dialConfig cấu hình dial, gắn purpose vào tên và client properties của connection
+// cùng TLSConfig, Heartbeat và DialTimeout
+func (c *Client) dialConfig(url string) amqp.Config {
+	props := amqp.NewConnectionProperties()
+	props.SetClientConnectionName("bunnyhop-" + c.config.Purpose.String())
+	props["bunnyhop_purpose"] = c.config.Purpose.String()
+	config := amqp.Config{
+		Locale:     "en_US",
+		Properties: props,
+		Heartbeat:  c.config.Heartbeat,
+	}
+	// TLSConfig chỉ dùng cho URL amqps://, URL amqp:// bỏ qua
+	if isTLSURL(url) {
+		config.TLSClientConfig = c.config.TLSConfig
+	}
+
+	// connection_timeout trong URL được amqp091 dùng khi Dial để trống
+	if c.config.DialTimeout > 0 {
+		if opts, err := parseURLOptions(url); err != nil || opts.ConnectionTimeout == 0 {
+			config.Dial = amqp.DefaultDial(c.config.DialTimeout)
+		}
+	}
+	return config
+}
+
+// isTLSURL cho biết URL dùng scheme amqps://
+func isTLSURL(url string) bool {
+	return strings.HasPrefix(strings.ToLower(url), "amqps://")
+}
+
+// setupMainChannel áp dụng QoS đặt qua SetQos, hoặc prefetch trong Config cho
+// channel chính theo Purpose. Phải giữ c.mutex
+func (c *Client) setupMainChannel(conn amqpConnection, ch amqpChannel) (amqpChannel, error) {
+	if c.qos != nil {
+		return c.applyQoS(conn, ch, *c.qos)
+	}
+	// Channel chính của publisher không nhận delivery nên không cần prefetch,
+	// channel mở theo yêu cầu khi DisableManagedChannel cũng không đặt QoS
+	if c.config.Purpose == PurposePublisher || c.config.DisableManagedChannel {
+		return ch, nil
+	}
+	return c.applyQoS(conn, ch, QoSProfile{
+		PrefetchCount: c.config.PrefetchCount,
+		PrefetchSize:  c.config.PrefetchSize,
+		Global:        c.config.GlobalQos,
+	})
+}
+
+// SetQos áp dụng QoS mới cho channel dùng chung và giữ lại để áp dụng sau mỗi
+// lần reconnect. Nếu client chưa kết nối, QoS được áp dụng ở lần connect tiếp theo
+func (c *Client) SetQos(prefetchCount, prefetchSize int, global bool) error {
+	qos := QoSProfile{PrefetchCount: prefetchCount, PrefetchSize: prefetchSize, Global: global}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.connected || c.connection == nil || c.channel == nil || c.channel.IsClosed() {
+		c.qos = &qos
+		return nil
+	}
 
+	ch, err := c.applyQoS(c.connection, c.channel, qos)
+	if err != nil {
+		c.channel = nil
+		return fmt.Errorf("failed to set QoS: %v", err)
+	}
+	c.qos = &qos
+	if ch != c.channel {
+		// Channel được mở lại theo QoSFailurePolicy
+		c.channel = ch
+		go c.watchChannel(ch.NotifyClose(make(chan *amqp.Error, 1)))
+		go c.watchReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+	}
+	c.logger().Info("Applied QoS prefetch count %d, prefetch size %d, global %t", prefetchCount, prefetchSize, global)
 	return nil
 }
 
-// setupErrorHandlers thiết lập xử lý lỗi
+// redact ẩn credentials trong URL trước khi log, trừ khi ShowCredentials được bật
+func (c *Client) redact(url string) string {
+	if c.config.ShowCredentials {
+		return url
+	}
+	return redactURL(url)
+}
+
+// closeWatch các channel nhận lỗi đóng connection và channel dùng chung của
+// một connection
+type closeWatch struct {
+	connection chan *amqp.Error
+	channel    chan *amqp.Error
+}
+
+// setupErrorHandlers đăng ký close notification của connection hiện tại và
+// chuyển cho reconnectWorker, phải giữ c.mutex
 func (c *Client) setupErrorHandlers() {
+	var watch closeWatch
 	if c.connection != nil {
-		c.connectionErrors = c.connection.NotifyClose(make(chan *amqp.Error, 1))
+		watch.connection = c.connection.NotifyClose(make(chan *amqp.Error, 1))
 	}
 	if c.channel != nil {
-		c.channelErrors = c.channel.NotifyClose(make(chan *amqp.Error, 1))
+		watch.channel = c.channel.NotifyClose(make(chan *amqp.Error, 1))
+		go c.watchReturns(c.channel.NotifyReturn(make(chan amqp.Return, 1)))
 	}
+
+	// Bỏ watch cũ worker chưa nhận, mọi lần gửi đều giữ c.mutex nên không block
+	select {
+	case <-c.closeWatches:
+	default:
+	}
+	c.closeWatches <- watch
 }
 
-// reconnectWorker xử lý reconnect tự động
+// reconnectWorker xử lý reconnect tự động. Worker chỉ theo dõi connection mới
+// nhất, channel nil (chưa có hoặc đã xử lý) không bao giờ sẵn sàng trong select
 func (c *Client) reconnectWorker() {
+	var watch closeWatch
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
-		case err := <-c.connectionErrors:
-			if err != nil {
-				c.logger().Error("Connection error: %v", err)
-				c.handleDisconnection()
+		case watch = <-c.closeWatches:
+		case err, ok := <-watch.connection:
+			watch = closeWatch{}
+			if !ok || err == nil {
+				// Connection đóng bình thường (Close)
+				continue
 			}
-		case err := <-c.channelErrors:
-			if err != nil {
-				c.logger().Error("Channel error: %v", err)
-				c.handleDisconnection()
+			c.logger().Error("Connection error: %v", err)
+			c.recordCloseError(err, false)
+			c.handleDisconnection()
+		case err, ok := <-watch.channel:
+			if !ok || err == nil {
+				watch.channel = nil
+				continue
 			}
+			watch = closeWatch{}
+			c.logger().Error("Channel error: %v", err)
+			c.recordCloseError(err, true)
+			c.notifyChannelClose(err)
+			c.handleDisconnection()
 		}
 	}
 }
@@ -191,8 +497,13 @@ func (c *Client) reconnect() {
 	}
 
 	c.logger().Info("Reconnection attempt %d/%d", c.reconnectAttempts, c.config.MaxReconnectAttempt)
+	delay := c.reconnectBackoff(c.reconnectAttempts)
 
 	// Đóng connection cũ nếu có
+	c.closeProfileChannels()
+	c.closePooledChannels()
+	c.closeConfirmChannel()
+	c.closeConsumerChannels()
 	if c.connection != nil {
 		c.connection.Close()
 		c.connection = nil
@@ -203,14 +514,65 @@ func (c *Client) reconnect() {
 	}
 
 	c.mutex.Unlock()
-	// Chờ một chút trước khi thử lại
-	time.Sleep(c.config.ReconnectInterval)
+	// Chờ một lần trước mỗi lần thử, lần thử sau tự chờ theo backoff của nó
+	timer := time.NewTimer(delay)
+	select {
+	case <-c.ctx.Done():
+		timer.Stop()
+		return
+	case <-timer.C:
+	}
 	// Thử kết nối lại
 	if err := c.Connect(c.ctx); err != nil {
-		c.logger().Error("Reconnection failed: %v", err)
-		// Thử lại sau một khoảng thời gian
-		time.AfterFunc(c.config.ReconnectInterval, c.reconnect)
+		if ok, suppressed := c.reconnectLog.check(err.Error(), c.config.ReconnectLogInterval); ok {
+			if suppressed > 0 {
+				c.logger().Error("Reconnection still failing after %d more attempts: %v", suppressed, err)
+			} else {
+				c.logger().Error("Reconnection failed: %v", err)
+			}
+		}
+		// Thử lại, khoảng chờ nằm ở đầu lần thử tiếp theo
+		go c.reconnect()
+		return
+	}
+	c.replayTopology()
+	c.resubscribeConsumers()
+}
+
+// reconnectBackoff trả về khoảng chờ trước lần reconnect thứ attempt (bắt đầu
+// từ 1): cố định ReconnectInterval, hoặc theo Backoff nếu được cấu hình
+func (c *Client) reconnectBackoff(attempt int) time.Duration {
+	if c.config.Backoff == nil {
+		return reconnectDelay(c.config.ReconnectInterval, c.config.MinReconnectInterval)
+	}
+	return c.config.Backoff.delay(attempt, c.config.ReconnectInterval, c.config.MinReconnectInterval)
+}
+
+// delay tính khoảng chờ trước lần thử thứ attempt: base nhân Multiplier^(attempt-1),
+// tối đa MaxInterval, chọn ngẫu nhiên trong [0, khoảng chờ] nếu Jitter, không nhỏ hơn floor
+func (b BackoffConfig) delay(attempt int, base, floor time.Duration) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	maxInterval := b.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = 8 * base
+	}
+
+	d := float64(base)
+	for i := 1; i < attempt && d < float64(maxInterval); i++ {
+		d *= multiplier
 	}
+	interval := maxInterval
+	if d < float64(maxInterval) {
+		interval = time.Duration(d)
+	}
+
+	if b.Jitter && interval > 0 {
+		interval = time.Duration(rand.Int63n(int64(interval) + 1))
+	}
+	return reconnectDelay(interval, floor)
 }
 
 // IsConnected kiểm tra trạng thái kết nối
@@ -220,8 +582,232 @@ func (c *Client) IsConnected() bool {
 	return c.connected && c.connection != nil && !c.connection.IsClosed()
 }
 
+// IsChannelOpen kiểm tra connection và channel chính đều dùng được
+func (c *Client) IsChannelOpen() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.connected && c.connection != nil && !c.connection.IsClosed() &&
+		c.channel != nil && !c.channel.IsClosed()
+}
+
+// RecoverChannel mở lại channel chính nếu nó đã bị đóng trong khi connection vẫn còn
+func (c *Client) RecoverChannel() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return fmt.Errorf("client is not connected")
+	}
+	if c.channel != nil && !c.channel.IsClosed() {
+		return nil
+	}
+
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %v", err)
+	}
+	ch, err = c.setupMainChannel(c.connection, ch)
+	if err != nil {
+		return fmt.Errorf("failed to set QoS: %v", err)
+	}
+
+	c.channel = ch
+	go c.watchChannel(ch.NotifyClose(make(chan *amqp.Error, 1)))
+	go c.watchReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+	c.logger().Info("Recovered closed channel")
+	return nil
+}
+
+// watchChannel xử lý lỗi của channel được mở lại bởi RecoverChannel
+func (c *Client) watchChannel(closes chan *amqp.Error) {
+	select {
+	case <-c.ctx.Done():
+	case err := <-closes:
+		if err != nil {
+			c.logger().Error("Channel error: %v", err)
+			c.recordCloseError(err, true)
+			c.notifyChannelClose(err)
+			c.handleDisconnection()
+		}
+	}
+}
+
+// keepaliveWorker định kỳ probe connection bằng một thao tác rẻ trên channel
+// dùng chung, giữ đường truyền (ví dụ qua NAT) không bị đóng và phát hiện
+// đường truyền chết sớm hơn heartbeat timeout
+func (c *Client) keepaliveWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.keepaliveProbe(interval); err != nil {
+				c.logger().Warn("Keepalive probe failed: %v", err)
+				c.handleDisconnection()
+			}
+		}
+	}
+}
+
+// keepaliveProbe probe channel dùng chung như Ping, lỗi nếu broker không trả
+// lời trong timeout. Bỏ qua khi đang reconnect hoặc không có channel dùng chung
+func (c *Client) keepaliveProbe(timeout time.Duration) error {
+	c.mutex.RLock()
+	ch := c.channel
+	usable := c.connected && ch != nil && !ch.IsClosed()
+	c.mutex.RUnlock()
+	if !usable {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	err := probeChannel(ctx, ch)
+	if c.ctx.Err() != nil {
+		return nil
+	}
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("no response from broker within %v", timeout)
+	}
+	return err
+}
+
+// Ping kiểm tra broker thực sự phản hồi trên channel dùng chung (không chỉ
+// socket còn mở). Lỗi trả về là *PingError: NotConnected cho biết client
+// không có channel dùng được, ngược lại broker không trả lời trước khi ctx
+// hết hạn hoặc trả lỗi
+func (c *Client) Ping(ctx context.Context) error {
+	ch, err := c.currentChannel()
+	if err != nil {
+		return &PingError{Err: err, notConnected: true}
+	}
+	ctx, cancel := c.withClientContext(ctx)
+	defer cancel()
+	if err := probeChannel(ctx, ch); err != nil {
+		return &PingError{Err: err}
+	}
+	return nil
+}
+
+// probeChannel khai báo passive exchange amq.direct (luôn tồn tại) trên ch,
+// một round-trip rẻ đến broker, và chờ tối đa đến khi ctx hết hạn
+func probeChannel(ctx context.Context, ch amqpChannel) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- ch.ExchangeDeclarePassive("amq.direct", amqp.ExchangeDirect, true, false, false, false, nil)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no response from broker: %w", ctx.Err())
+	}
+}
+
+// NegotiatedHeartbeat trả về heartbeat đã thỏa thuận với broker của connection hiện tại
+func (c *Client) NegotiatedHeartbeat() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.heartbeat
+}
+
+// ConnectionInfo trả về thông số đã thỏa thuận của connection hiện tại
+func (c *Client) ConnectionInfo() (ConnectionInfo, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return ConnectionInfo{}, fmt.Errorf("client is not connected")
+	}
+
+	config := c.connection.NegotiatedConfig()
+	properties := make(amqp.Table)
+	for k, v := range c.connection.ServerProperties() {
+		properties[k] = v
+	}
+	return ConnectionInfo{
+		FrameMax:         config.FrameSize,
+		ChannelMax:       int(config.ChannelMax),
+		Heartbeat:        config.Heartbeat,
+		Vhost:            config.Vhost,
+		Locale:           config.Locale,
+		ServerProperties: properties,
+	}, nil
+}
+
 // GetChannel lấy channel hiện tại
 func (c *Client) GetChannel() (*amqp.Channel, error) {
+	ch, err := c.currentChannel()
+	if err != nil && c.config.DisableManagedChannel {
+		// Channel chưa được mở khi connect, mở theo yêu cầu
+		ch, err = c.usableChannel()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return asAMQPChannel(ch)
+}
+
+// WithChannel chạy fn trên channel dùng chung, hoặc channel mượn từ channel
+// pool khi MaxChannels > 0, xem ChannelResult
+func (c *Client) WithChannel(fn func(ch *amqp.Channel) error) error {
+	_, err := ChannelResult(c, func(ch *amqp.Channel) (struct{}, error) {
+		return struct{}{}, fn(ch)
+	})
+	return err
+}
+
+// ChannelResult chạy fn trên channel dùng chung của client (hoặc channel mượn
+// từ channel pool) và trả về kết quả của fn. Nếu channel đã bị đóng, fn được
+// chạy lại một lần trên channel mở lại.
+func ChannelResult[T any](c *Client, fn func(ch *amqp.Channel) (T, error)) (T, error) {
+	return channelResult(c, func(ch amqpChannel) (T, error) {
+		raw, err := asAMQPChannel(ch)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		return fn(raw)
+	})
+}
+
+// channelResult là phần của ChannelResult làm việc trên amqpChannel
+func channelResult[T any](c *Client, fn func(ch amqpChannel) (T, error)) (T, error) {
+	var zero T
+	ch, release, err := c.lendChannel(c.ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	result, err := fn(ch)
+	release()
+	if !errors.Is(err, amqp.ErrClosed) {
+		return result, err
+	}
+
+	c.logger().Debug("Channel closed, retrying once on a new channel: %v", err)
+	ch, release, err = c.lendChannel(c.ctx)
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+	return fn(ch)
+}
+
+// usableChannel trả về channel dùng chung, mở lại nếu nó đã đóng trong khi connection còn
+func (c *Client) usableChannel() (amqpChannel, error) {
+	if err := c.RecoverChannel(); err != nil {
+		return nil, err
+	}
+	return c.currentChannel()
+}
+
+// currentChannel lấy channel dùng chung hiện tại
+func (c *Client) currentChannel() (amqpChannel, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
@@ -232,31 +818,136 @@ func (c *Client) GetChannel() (*amqp.Channel, error) {
 	return c.channel, nil
 }
 
+// GetChannelWithProfile lấy channel riêng đã áp dụng QoS của profile
+func (c *Client) GetChannelWithProfile(profile string) (*amqp.Channel, error) {
+	ch, err := c.profileChannel(profile)
+	if err != nil {
+		return nil, err
+	}
+	return asAMQPChannel(ch)
+}
+
+// profileChannel lấy (hoặc mở) channel của một QoS profile
+func (c *Client) profileChannel(profile string) (amqpChannel, error) {
+	qos, ok := c.config.QoSProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown QoS profile: %s", profile)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return nil, fmt.Errorf("client is not connected")
+	}
+
+	if ch, ok := c.profileChannels[profile]; ok && !ch.IsClosed() {
+		return ch, nil
+	}
+
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel for profile %s: %v", profile, err)
+	}
+	ch, err = c.applyQoS(c.connection, ch, qos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set QoS for profile %s: %v", profile, err)
+	}
+
+	c.profileChannels[profile] = ch
+	c.logger().Debug("Opened channel for QoS profile %s", profile)
+	return ch, nil
+}
+
+// applyQoS thiết lập QoS cho channel theo QoSFailurePolicy. Broker đóng channel
+// khi từ chối QoS nên channel trả về có thể là channel mới; lỗi thì ch đã được đóng.
+func (c *Client) applyQoS(conn amqpConnection, ch amqpChannel, qos QoSProfile) (amqpChannel, error) {
+	if qos.PrefetchCount == 0 && qos.PrefetchSize == 0 && !c.config.AllowUnlimitedPrefetch {
+		c.logger().Warn("QoS prefetch is 0 (unlimited), consumers may buffer an unbounded number of messages; " +
+			"set AllowUnlimitedPrefetch to silence this warning")
+	}
+
+	err := ch.Qos(qos.PrefetchCount, qos.PrefetchSize, qos.Global)
+	if err == nil {
+		return ch, nil
+	}
+
+	switch c.config.QoSFailurePolicy {
+	case QoSFailureRetryWithoutGlobal:
+		if !qos.Global {
+			break
+		}
+		c.logger().Warn("Broker rejected global QoS, retrying without global flag: %v", err)
+		ch, err = reopenChannel(conn, ch)
+		if err != nil {
+			return nil, err
+		}
+		if err = ch.Qos(qos.PrefetchCount, qos.PrefetchSize, false); err == nil {
+			return ch, nil
+		}
+	case QoSFailureIgnore:
+		c.logger().Warn("Broker rejected QoS, continuing without QoS: %v", err)
+		return reopenChannel(conn, ch)
+	}
+
+	ch.Close()
+	return nil, err
+}
+
+// reopenChannel mở channel mới nếu ch đã bị broker đóng
+func reopenChannel(conn amqpConnection, ch amqpChannel) (amqpChannel, error) {
+	if !ch.IsClosed() {
+		return ch, nil
+	}
+	newCh, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen channel: %v", err)
+	}
+	return newCh, nil
+}
+
+// closeProfileChannels đóng các channel của QoS profile
+func (c *Client) closeProfileChannels() {
+	for name, ch := range c.profileChannels {
+		ch.Close()
+		delete(c.profileChannels, name)
+	}
+}
+
 // GetConnection lấy connection hiện tại
 func (c *Client) GetConnection() (*amqp.Connection, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	if !c.connected || c.connection != nil || c.connection.IsClosed() {
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
 		return nil, fmt.Errorf("client is not connected")
 	}
 
-	return c.connection, nil
+	return asAMQPConnection(c.connection)
 }
 
-// Close đóng kết nối
+// Close đóng kết nối, chờ handler của consumer tối đa DrainTimeout. Các
+// PublishWithConfirm đang chờ bị hủy, gọi WaitForConfirms trước để chờ confirm
 func (c *Client) Close() error {
+	// Hủy context trước khi chờ lock để các publish đang chạy dừng ngay
+	c.cancel()
+
+	c.StopConsumers()
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.cancel()
-
 	if c.reconnectTicker != nil {
 		c.reconnectTicker.Stop()
 	}
 
 	var errs []error
 
+	c.closeProfileChannels()
+	c.closePooledChannels()
+	c.closeConfirmChannel()
+	c.closeConsumers()
+
 	if c.channel != nil {
 		if err := c.channel.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("failed to close channel: %v", err))
@@ -281,6 +972,16 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// withClientContext trả về context bị hủy khi ctx hoặc vòng đời client kết thúc
+func (c *Client) withClientContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
 // logger helper để lấy logger
 func (c *Client) logger() Logger {
 	return c.config.Logger
@@ -292,12 +993,108 @@ func (c *Client) PublishMessage(
 	mandatory, immediate bool,
 	msg amqp.Publishing,
 ) error {
-	ch, err := c.GetChannel()
+	return c.PublishMessageContext(context.Background(), exchange, routingKey, mandatory, immediate, msg)
+}
+
+// PublishMessageContext gửi message, hủy khi ctx hết hạn hoặc client bị đóng
+func (c *Client) PublishMessageContext(
+	ctx context.Context,
+	exchange, routingKey string,
+	mandatory, immediate bool,
+	msg amqp.Publishing,
+) error {
+	msg.Headers = mergeHeaders(c.config.DefaultHeaders, msg.Headers)
+
+	// DryRun không mượn channel nào
+	if c.config.DryRun {
+		c.logDryRun(exchange, routingKey, msg)
+		return nil
+	}
+
+	ctx, cancel := c.withClientContext(ctx)
+	defer cancel()
+
+	ch, release, err := c.publishChannel(ctx)
 	if err != nil {
-		return err
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return newPublishError(err)
+	}
+	defer release()
+
+	if err := c.ensureExchange(exchange); err != nil {
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return newPublishError(err)
+	}
+
+	if err := ch.PublishWithContext(ctx, exchange, routingKey, mandatory, immediate, msg); err != nil {
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return newPublishError(err)
 	}
+	return nil
+}
+
+// publishChannel lấy channel để publish: channel mượn từ channel pool, hoặc
+// channel dùng chung khi client không có channel pool
+func (c *Client) publishChannel(ctx context.Context) (amqpChannel, func(), error) {
+	if c.channels != nil {
+		return c.channels.acquire(ctx, c.openPooledChannel)
+	}
+	ch, err := c.currentChannel()
+	return ch, func() {}, err
+}
 
-	return ch.Publish(exchange, routingKey, mandatory, immediate, msg)
+// PublishRouted gửi message với routing key do router tính từ nội dung message
+func (c *Client) PublishRouted(
+	ctx context.Context,
+	exchange string,
+	router func(msg amqp.Publishing) string,
+	msg amqp.Publishing,
+) error {
+	if router == nil {
+		return fmt.Errorf("router is required")
+	}
+	return c.PublishMessageContext(ctx, exchange, router(msg), false, false, msg)
+}
+
+// PublishWithIDs gửi message với message id và correlation id để tracing
+func (c *Client) PublishWithIDs(
+	exchange, routingKey string,
+	messageID, correlationID string,
+	msg amqp.Publishing,
+) error {
+	if messageID == "" && c.config.GenerateMessageID {
+		id, err := newUUID()
+		if err != nil {
+			return fmt.Errorf("failed to generate message id: %v", err)
+		}
+		messageID = id
+	}
+	msg.MessageId = messageID
+	msg.CorrelationId = correlationID
+
+	return c.PublishMessage(exchange, routingKey, false, false, msg)
+}
+
+// PublishJSON mã hóa v bằng JSONCodec và publish với confirm (xem
+// PublishWithConfirm). Message được gắn content type, timestamp và message id
+// ngẫu nhiên. Lỗi marshal được trả về trước khi dùng đến channel.
+func (c *Client) PublishJSON(ctx context.Context, exchange, routingKey string, v interface{}) error {
+	codec := JSONCodec{}
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+	id, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate message id: %v", err)
+	}
+
+	return c.PublishWithConfirm(ctx, exchange, routingKey, false, amqp.Publishing{
+		ContentType: codec.ContentType(),
+		Timestamp:   time.Now(),
+		MessageId:   id,
+		Body:        body,
+	})
 }
 
 // DeclareQueue khai báo queue
@@ -306,12 +1103,27 @@ func (c *Client) DeclareQueue(
 	durable, autoDelete, exclusive bool,
 	args amqp.Table,
 ) (amqp.Queue, error) {
-	ch, err := c.GetChannel()
+	ch, err := c.currentChannel()
 	if err != nil {
 		return amqp.Queue{}, err
 	}
 
-	return ch.QueueDeclare(name, durable, autoDelete, exclusive, false, args)
+	queue, err := ch.QueueDeclare(name, durable, autoDelete, exclusive, false, args)
+	if err != nil {
+		return queue, err
+	}
+
+	// Queue do server đặt tên không thể khai báo lại
+	if name != "" {
+		c.topology.recordQueue(QueueDeclaration{
+			Name:       name,
+			Durable:    durable,
+			AutoDelete: autoDelete,
+			Exclusive:  exclusive,
+			Args:       args,
+		})
+	}
+	return queue, nil
 }
 
 // DeclareExchange khai báo exchange
@@ -320,20 +1132,80 @@ func (c *Client) DeclareExchange(
 	durable, autoDelete, internal bool,
 	args amqp.Table,
 ) error {
-	ch, err := c.GetChannel()
+	ch, err := c.currentChannel()
 	if err != nil {
 		return err
 	}
 
-	return ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, false, args)
+	if err := ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, false, args); err != nil {
+		return err
+	}
+
+	c.topology.recordExchange(ExchangeDeclaration{
+		Name:       name,
+		Kind:       kind,
+		Durable:    durable,
+		AutoDelete: autoDelete,
+		Internal:   internal,
+		Args:       args,
+	})
+	return nil
+}
+
+// logDryRun ghi log message bị bỏ qua ở chế độ DryRun
+func (c *Client) logDryRun(exchange, routingKey string, msg amqp.Publishing) {
+	c.logger().Debug("Dry run: skipped publish to exchange=%s routing_key=%s (%d bytes)", exchange, routingKey, len(msg.Body))
+}
+
+// ensureExchange tự khai báo exchange nếu AutoDeclareExchange được cấu hình và
+// exchange chưa được khai báo trên connection hiện tại
+func (c *Client) ensureExchange(exchange string) error {
+	opts := c.config.AutoDeclareExchange
+	if opts == nil || exchange == "" || strings.HasPrefix(exchange, "amq.") {
+		return nil
+	}
+
+	c.mutex.RLock()
+	declared := c.declaredExchanges[exchange]
+	c.mutex.RUnlock()
+	if declared {
+		return nil
+	}
+
+	kind := opts.Kind
+	if kind == "" {
+		kind = amqp.ExchangeDirect
+	}
+	if err := c.DeclareExchange(exchange, kind, opts.Durable, opts.AutoDelete, false, opts.Args); err != nil {
+		return fmt.Errorf("failed to auto-declare exchange %s: %w", exchange, err)
+	}
+
+	c.mutex.Lock()
+	if c.declaredExchanges != nil {
+		c.declaredExchanges[exchange] = true
+	}
+	c.mutex.Unlock()
+
+	c.logger().Debug("Auto-declared exchange %s", exchange)
+	return nil
 }
 
 // QueueBind bind queue với exchange
 func (c *Client) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
-	ch, err := c.GetChannel()
+	ch, err := c.currentChannel()
 	if err != nil {
 		return err
 	}
 
-	return ch.QueueBind(name, key, exchange, noWait, args)
+	if err := ch.QueueBind(name, key, exchange, noWait, args); err != nil {
+		return err
+	}
+
+	c.topology.recordBinding(BindingDeclaration{
+		Queue:      name,
+		RoutingKey: key,
+		Exchange:   exchange,
+		Args:       args,
+	})
+	return nil
 }
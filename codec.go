@@ -0,0 +1,28 @@
+package bunnyhop
+
+import "encoding/json"
+
+// Codec mã hóa và giải mã body của message
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	ContentType() string // Content type gắn vào message được mã hóa
+}
+
+// JSONCodec mã hóa body bằng encoding/json, là codec mặc định
+type JSONCodec struct{}
+
+// Marshal mã hóa v thành JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal giải mã JSON vào v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ContentType trả về "application/json"
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
@@ -0,0 +1,246 @@
+package bunnyhop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// confirmBuffer kích thước buffer nhận confirm từ broker
+const confirmBuffer = 128
+
+// confirmTracker theo dõi các publish đang chờ confirm trên một confirm channel.
+// Nhiều publisher dùng chung channel, mỗi publisher chờ trên waiter của delivery
+// tag của mình và được đánh thức khi broker ack/nack tag đó.
+type confirmTracker struct {
+	channel amqpChannel
+
+	publishMutex sync.Mutex // Tuần tự hóa publish để delivery tag tăng đúng thứ tự
+	nextTag      uint64     // Delivery tag đã publish gần nhất, bảo vệ bởi publishMutex
+
+	mutex     sync.Mutex
+	waiters   map[uint64]chan amqp.Confirmation
+	published uint64        // Delivery tag đã publish gần nhất, dùng cho WaitForConfirms
+	confirmed uint64        // Delivery tag đã nhận confirm gần nhất
+	progress  chan struct{} // Được đóng và thay mới mỗi khi nhận confirm hoặc channel đóng
+	closed    bool
+}
+
+// newConfirmTracker tạo tracker và chạy goroutine phân phát confirm
+func newConfirmTracker(ch amqpChannel, confirms chan amqp.Confirmation) *confirmTracker {
+	t := &confirmTracker{
+		channel:  ch,
+		waiters:  make(map[uint64]chan amqp.Confirmation),
+		progress: make(chan struct{}),
+	}
+	go t.dispatch(confirms)
+	return t
+}
+
+// dispatch chuyển mỗi confirm đến waiter của delivery tag tương ứng. Khi channel
+// đóng, mọi waiter còn lại nhận lỗi.
+func (t *confirmTracker) dispatch(confirms chan amqp.Confirmation) {
+	for conf := range confirms {
+		t.mutex.Lock()
+		waiter := t.waiters[conf.DeliveryTag]
+		delete(t.waiters, conf.DeliveryTag)
+		if conf.DeliveryTag > t.confirmed {
+			t.confirmed = conf.DeliveryTag
+		}
+		t.notifyProgress()
+		t.mutex.Unlock()
+
+		if waiter != nil {
+			waiter <- conf
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+	for tag, waiter := range t.waiters {
+		close(waiter)
+		delete(t.waiters, tag)
+	}
+	t.notifyProgress()
+}
+
+// notifyProgress đánh thức các WaitForConfirms đang chờ, phải giữ t.mutex
+func (t *confirmTracker) notifyProgress() {
+	close(t.progress)
+	t.progress = make(chan struct{})
+}
+
+// publish gửi message và trả về waiter nhận confirm của nó
+func (t *confirmTracker) publish(
+	ctx context.Context,
+	exchange, routingKey string,
+	mandatory bool,
+	msg amqp.Publishing,
+) (chan amqp.Confirmation, error) {
+	t.publishMutex.Lock()
+	defer t.publishMutex.Unlock()
+
+	// Đăng ký waiter trước khi publish để không bỏ lỡ confirm đến sớm
+	tag := t.nextTag + 1
+	waiter := make(chan amqp.Confirmation, 1)
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("confirm channel closed")
+	}
+	t.waiters[tag] = waiter
+	t.mutex.Unlock()
+
+	if err := t.channel.PublishWithContext(ctx, exchange, routingKey, mandatory, false, msg); err != nil {
+		t.mutex.Lock()
+		delete(t.waiters, tag)
+		t.mutex.Unlock()
+		return nil, err
+	}
+
+	t.nextTag = tag
+	t.mutex.Lock()
+	t.published = tag
+	t.mutex.Unlock()
+	return waiter, nil
+}
+
+// wait chờ đến khi mọi tag đã publish tính đến lúc gọi được confirm
+func (t *confirmTracker) wait(ctx context.Context) error {
+	t.mutex.Lock()
+	target := t.published
+	for t.confirmed < target && !t.closed {
+		progress := t.progress
+		t.mutex.Unlock()
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		t.mutex.Lock()
+	}
+	t.mutex.Unlock()
+	return nil
+}
+
+// PublishWithConfirm publish message trên confirm channel dùng chung và chờ
+// broker ack/nack. An toàn khi gọi đồng thời, mỗi caller chỉ chờ confirm của mình.
+func (c *Client) PublishWithConfirm(
+	ctx context.Context,
+	exchange, routingKey string,
+	mandatory bool,
+	msg amqp.Publishing,
+) error {
+	ctx, cancel := c.withClientContext(ctx)
+	defer cancel()
+
+	msg.Headers = mergeHeaders(c.config.DefaultHeaders, msg.Headers)
+
+	if c.config.DryRun {
+		c.logDryRun(exchange, routingKey, msg)
+		return nil
+	}
+
+	if err := c.ensureExchange(exchange); err != nil {
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return err
+	}
+
+	tracker, err := c.ensureConfirmChannel()
+	if err != nil {
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return err
+	}
+
+	start := time.Now()
+	waiter, err := tracker.publish(ctx, exchange, routingKey, mandatory, msg)
+	if err != nil {
+		c.config.FailedPublishSink.Record(exchange, routingKey, msg, fmt.Sprintf("publish error: %v", err))
+		return err
+	}
+
+	select {
+	case conf, ok := <-waiter:
+		if !ok {
+			return fmt.Errorf("confirm channel closed before confirmation")
+		}
+		if !conf.Ack {
+			c.config.FailedPublishSink.Record(exchange, routingKey, msg, "nacked by broker")
+			return fmt.Errorf("message was nacked by broker (delivery tag %d)", conf.DeliveryTag)
+		}
+		c.recordConfirmLatency(time.Since(start))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ConfirmLatency trả về EWMA của thời gian từ lúc publish đến khi broker ack
+func (c *Client) ConfirmLatency() time.Duration {
+	return c.confirmLatency.get()
+}
+
+// recordConfirmLatency ghi lại latency của một confirm
+func (c *Client) recordConfirmLatency(latency time.Duration) {
+	c.confirmLatency.observe(latency)
+	if c.config.LatencyRecorder != nil {
+		c.config.LatencyRecorder.RecordLatency(OperationConfirm, latency)
+	}
+}
+
+// WaitForConfirms chờ đến khi mọi message đã publish trên confirm channel
+// được broker confirm, hoặc ctx hết hạn. Nên gọi trước Close.
+func (c *Client) WaitForConfirms(ctx context.Context) error {
+	c.mutex.RLock()
+	tracker := c.confirms
+	c.mutex.RUnlock()
+
+	if tracker == nil {
+		return nil
+	}
+	return tracker.wait(ctx)
+}
+
+// ensureConfirmChannel mở confirm channel nếu chưa có hoặc đã bị đóng
+func (c *Client) ensureConfirmChannel() (*confirmTracker, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.confirmChannel()
+}
+
+// confirmChannel trả về confirm channel hiện tại hoặc mở mới, phải giữ c.mutex
+func (c *Client) confirmChannel() (*confirmTracker, error) {
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return nil, fmt.Errorf("client is not connected")
+	}
+
+	if c.confirms != nil && !c.confirms.channel.IsClosed() {
+		return c.confirms, nil
+	}
+
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open confirm channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable confirm mode: %v", err)
+	}
+
+	c.confirms = newConfirmTracker(ch, ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer)))
+	go c.watchReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+	c.logger().Debug("Opened confirm channel")
+	return c.confirms, nil
+}
+
+// closeConfirmChannel đóng confirm channel hiện tại, phải giữ c.mutex
+func (c *Client) closeConfirmChannel() {
+	if c.confirms != nil {
+		c.confirms.channel.Close()
+		c.confirms = nil
+	}
+}
@@ -0,0 +1,323 @@
+package bunnyhop
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_WaitForConfirmsBlocksUntilPendingConfirmed(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	publishDone := make(chan error, 1)
+	go func() {
+		publishDone <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, 5*time.Millisecond)
+	confirmCh := conn.channel(1)
+
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- client.WaitForConfirms(context.Background())
+	}()
+
+	select {
+	case <-waitDone:
+		t.Fatal("WaitForConfirms returned while a confirm was pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	confirmCh.sendConfirm(1, true)
+
+	require.NoError(t, <-publishDone)
+	require.NoError(t, <-waitDone)
+}
+
+func TestClient_WaitForConfirmsRespectsContext(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	// Publish bị hủy trước khi nhận confirm, tag vẫn còn đang chờ
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := client.PublishWithConfirm(ctx, "ex", "key", false, amqp.Publishing{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer waitCancel()
+	assert.ErrorIs(t, client.WaitForConfirms(waitCtx), context.DeadlineExceeded)
+
+	dialer.last().channel(1).sendConfirm(1, true)
+	assert.NoError(t, client.WaitForConfirms(context.Background()))
+}
+
+type recordedLatency struct {
+	operation string
+	latency   time.Duration
+}
+
+// fakeLatencyRecorder ghi lại các latency nhận được
+type fakeLatencyRecorder struct {
+	mu      sync.Mutex
+	records []recordedLatency
+}
+
+func (r *fakeLatencyRecorder) RecordLatency(operation string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, recordedLatency{operation, latency})
+}
+
+func TestClient_ConfirmLatencyIsRecorded(t *testing.T) {
+	recorder := &fakeLatencyRecorder{}
+	pool, dialer := newFakePool(PoolConfig{
+		URLs:            []string{"amqp://guest:guest@a:5672/"},
+		LatencyRecorder: recorder,
+	})
+	require.NoError(t, pool.Start())
+	defer pool.Close()
+	require.Eventually(t, func() bool { return pool.GetHealthyNodeCount() == 1 }, time.Second, 5*time.Millisecond)
+	client, err := pool.GetClient()
+	require.NoError(t, err)
+
+	publishDone := make(chan error, 1)
+	go func() {
+		publishDone <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, time.Millisecond)
+
+	time.Sleep(30 * time.Millisecond)
+	conn.channel(1).sendConfirm(1, true)
+	require.NoError(t, <-publishDone)
+
+	latency := pool.GetStats().NodesStats[0].ConfirmLatency
+	assert.GreaterOrEqual(t, latency, 30*time.Millisecond)
+	assert.Less(t, latency, time.Second)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	require.Len(t, recorder.records, 1)
+	assert.Equal(t, OperationConfirm, recorder.records[0].operation)
+	assert.Equal(t, latency, recorder.records[0].latency)
+}
+
+func TestClient_ConcurrentPublishWithConfirmShareChannel(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	const publishers = 50
+	results := make(chan error, publishers)
+	for i := 0; i < publishers; i++ {
+		go func() {
+			results <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+		}()
+	}
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == publishers
+	}, time.Second, time.Millisecond)
+
+	// Mọi publisher dùng chung một confirm channel
+	conn.mu.Lock()
+	assert.Len(t, conn.channels, 2)
+	conn.mu.Unlock()
+
+	// Broker nack tag 7, ack các tag còn lại theo thứ tự
+	confirmCh := conn.channel(1)
+	for tag := uint64(1); tag <= publishers; tag++ {
+		confirmCh.sendConfirm(tag, tag != 7)
+	}
+
+	var nacked int
+	for i := 0; i < publishers; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				assert.Contains(t, err.Error(), "delivery tag 7")
+				nacked++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("publisher was not woken by its confirm")
+		}
+	}
+	assert.Equal(t, 1, nacked)
+	assert.NoError(t, client.WaitForConfirms(context.Background()))
+}
+
+func TestClient_PublishWithConfirmRecreatesClosedChannel(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, time.Millisecond)
+
+	// Channel bị đóng trước khi confirm, publisher đang chờ nhận lỗi
+	conn.channel(1).closeWithError(&amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED"})
+	select {
+	case err := <-done:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "closed before confirmation")
+	case <-time.After(time.Second):
+		t.Fatal("publisher was not released when channel closed")
+	}
+
+	go func() {
+		done <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 3 && conn.channels[2].publishedCount() == 1
+	}, time.Second, time.Millisecond)
+	conn.channel(2).sendConfirm(1, true)
+	require.NoError(t, <-done)
+}
+
+func TestClient_PublishWithConfirmLeavesSharedChannelUnconfirmed(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, time.Millisecond)
+	conn.channel(1).sendConfirm(1, true)
+	require.NoError(t, <-done)
+
+	// Chỉ confirm channel ở confirm mode, publish thường vẫn đi trên channel chính
+	conn.channel(0).mu.Lock()
+	assert.False(t, conn.channel(0).confirm)
+	conn.channel(0).mu.Unlock()
+	conn.channel(1).mu.Lock()
+	assert.True(t, conn.channel(1).confirm)
+	conn.channel(1).mu.Unlock()
+
+	require.NoError(t, client.PublishMessage("ex", "key", false, false, amqp.Publishing{}))
+	assert.Equal(t, 1, conn.channel(0).publishedCount())
+	assert.Equal(t, 1, conn.channel(1).publishedCount())
+}
+
+func TestClient_PublishJSON(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+	conn := dialer.last()
+
+	type order struct {
+		ID    int    `json:"id"`
+		State string `json:"state"`
+	}
+	values := []interface{}{
+		order{ID: 7, State: "created"},
+		map[string]int{"count": 3},
+	}
+	bodies := []string{`{"id":7,"state":"created"}`, `{"count":3}`}
+
+	for i, v := range values {
+		done := make(chan error, 1)
+		go func() { done <- client.PublishJSON(context.Background(), "ex", "key", v) }()
+		require.Eventually(t, func() bool {
+			conn.mu.Lock()
+			defer conn.mu.Unlock()
+			return len(conn.channels) == 2 && conn.channels[1].publishedCount() == i+1
+		}, time.Second, 5*time.Millisecond)
+		conn.channel(1).sendConfirm(uint64(i+1), true)
+		require.NoError(t, <-done)
+	}
+
+	confirmCh := conn.channel(1)
+	confirmCh.mu.Lock()
+	published := append([]fakePublish(nil), confirmCh.published...)
+	confirmCh.mu.Unlock()
+	for i, p := range published {
+		assert.Equal(t, "ex", p.Exchange)
+		assert.Equal(t, "key", p.RoutingKey)
+		assert.Equal(t, "application/json", p.Msg.ContentType)
+		assert.JSONEq(t, bodies[i], string(p.Msg.Body))
+		assert.NotEmpty(t, p.Msg.MessageId)
+		assert.WithinDuration(t, time.Now(), p.Msg.Timestamp, time.Minute)
+	}
+	assert.NotEqual(t, published[0].Msg.MessageId, published[1].Msg.MessageId)
+
+	// Lỗi marshal được trả về trước khi publish
+	err := client.PublishJSON(context.Background(), "ex", "key", make(chan int))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to marshal message")
+	assert.Equal(t, 2, confirmCh.publishedCount())
+}
+
+func TestPool_ShutdownDrainsPendingConfirms(t *testing.T) {
+	pool, dialer := newFakePool(PoolConfig{URLs: []string{"amqp://guest:guest@a:5672/"}, Logger: &captureLogger{}})
+	require.NoError(t, pool.Start())
+	require.Eventually(t, func() bool { return pool.GetHealthyNodeCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	client, err := pool.GetClient()
+	require.NoError(t, err)
+	publishDone := make(chan error, 1)
+	go func() {
+		publishDone <- client.PublishWithConfirm(context.Background(), "ex", "key", false, amqp.Publishing{})
+	}()
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Shutdown chờ confirm trong khi ctx còn hạn
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- pool.Shutdown(ctx) }()
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown returned while a confirm was pending")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	conn.channel(1).sendConfirm(1, true)
+	require.NoError(t, <-publishDone)
+	require.NoError(t, <-shutdownDone)
+}
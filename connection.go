@@ -0,0 +1,95 @@
+package bunnyhop
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpConnection là phần của *amqp.Connection mà Client sử dụng
+type amqpConnection interface {
+	Channel() (amqpChannel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	IsClosed() bool
+	Close() error
+	NegotiatedConfig() amqp.Config
+	ServerProperties() amqp.Table
+}
+
+// amqpChannel là phần của *amqp.Channel mà Client sử dụng
+type amqpChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	IsClosed() bool
+	Close() error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Nack(tag uint64, multiple, requeue bool) error
+}
+
+// defaultHeartbeat heartbeat mà amqp091 yêu cầu khi không cấu hình
+const defaultHeartbeat = 10 * time.Second
+
+// dialFunc mở connection đến một URL với cấu hình cho trước
+type dialFunc func(url string, config amqp.Config) (amqpConnection, error)
+
+// amqpConnectionAdapter bọc *amqp.Connection để thỏa mãn amqpConnection
+type amqpConnectionAdapter struct {
+	*amqp.Connection
+}
+
+// Channel mở channel mới trên connection
+func (a amqpConnectionAdapter) Channel() (amqpChannel, error) {
+	ch, err := a.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// NegotiatedConfig trả về cấu hình đã thỏa thuận với broker
+func (a amqpConnectionAdapter) NegotiatedConfig() amqp.Config {
+	return a.Connection.Config
+}
+
+// ServerProperties trả về server properties broker gửi trong connection.start
+func (a amqpConnectionAdapter) ServerProperties() amqp.Table {
+	return a.Connection.Properties
+}
+
+// defaultDial kết nối bằng amqp.DialConfig
+func defaultDial(url string, config amqp.Config) (amqpConnection, error) {
+	conn, err := amqp.DialConfig(url, config)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnectionAdapter{conn}, nil
+}
+
+// asAMQPChannel chuyển channel về *amqp.Channel cho API public
+func asAMQPChannel(ch amqpChannel) (*amqp.Channel, error) {
+	raw, ok := ch.(*amqp.Channel)
+	if !ok {
+		return nil, fmt.Errorf("channel is not an AMQP channel")
+	}
+	return raw, nil
+}
+
+// asAMQPConnection chuyển connection về *amqp.Connection cho API public
+func asAMQPConnection(conn amqpConnection) (*amqp.Connection, error) {
+	adapter, ok := conn.(amqpConnectionAdapter)
+	if !ok {
+		return nil, fmt.Errorf("connection is not an AMQP connection")
+	}
+	return adapter.Connection, nil
+}
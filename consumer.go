@@ -0,0 +1,763 @@
+package bunnyhop
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ConsumeOptions tùy chọn cho consumer
+type ConsumeOptions struct {
+	ConsumerTag string        // Tag của consumer, tự sinh nếu rỗng
+	AutoAck     bool          // Broker tự ack khi giao message
+	Exclusive   bool          // Consumer độc quyền trên queue
+	Args        amqp.Table    // Tham số bổ sung cho basic.consume
+	Workers     int           // Số goroutine xử lý song song, mặc định 1
+	QoSProfile  string        // QoS profile áp dụng cho channel của consumer, rỗng thì dùng QoS của client (SetQos hoặc prefetch trong Config)
+	Timeout     time.Duration // Chỉ dùng cho ConsumeN: thời gian chờ tối đa, 0 là chờ đến khi đủ N message
+	PanicPolicy PanicPolicy   // Cách xử lý delivery khi handler panic, mặc định PanicRequeue
+	AckPolicy   AckPolicy     // Chỉ dùng cho ConsumeWithAck: cách nack khi handler trả lỗi, mặc định NackRequeue
+
+	// Checkpointer nếu khác nil, offset của stream queue đã xử lý được lưu mỗi
+	// CheckpointInterval (mặc định 5s) và consumer tiếp tục từ offset kế tiếp khi
+	// subscribe lại. Checkpoint gắn với queue và ConsumerTag nên tag cần cố định.
+	// Với Workers > 1, offset lưu là offset lớn nhất đã xử lý xong.
+	Checkpointer       Checkpointer
+	CheckpointInterval time.Duration
+
+	// OrderByKey nếu khác nil, các delivery có cùng key được xử lý tuần tự
+	// trên cùng một worker, các key khác nhau vẫn chạy song song
+	OrderByKey func(amqp.Delivery) string
+
+	// DeliveryBuffer số delivery tối đa chờ trong hàng đợi nội bộ giữa consumer
+	// và các worker khi dùng OrderByKey (chia đều cho các worker, ít nhất 1 mỗi
+	// worker), mặc định bằng prefetch count. Broker chỉ gửi tối đa prefetch
+	// delivery chưa ack nên buffer lớn hơn prefetch không bao giờ đầy; với
+	// prefetch 0 (không giới hạn) thư viện amqp tự đệm delivery không giới hạn.
+	DeliveryBuffer int
+}
+
+// PanicPolicy cách xử lý delivery khi handler panic
+type PanicPolicy int
+
+const (
+	PanicRequeue    PanicPolicy = iota // Nack và requeue để xử lý lại
+	PanicDeadLetter                    // Nack không requeue, message đi vào DLX nếu queue có cấu hình
+)
+
+// AckPolicy cách xử lý delivery khi handler của ConsumeWithAck trả lỗi
+type AckPolicy int
+
+const (
+	NackRequeue    AckPolicy = iota // Nack và requeue để xử lý lại
+	NackDeadLetter                  // Nack không requeue, message đi vào DLX nếu queue có cấu hình
+)
+
+// ConsumerInfo trạng thái của một consumer đã đăng ký
+type ConsumerInfo struct {
+	Tag      string `json:"tag"`
+	Queue    string `json:"queue"`
+	Prefetch int    `json:"prefetch"`
+	Workers  int    `json:"workers"`
+	Running  bool   `json:"running"` // Channel của consumer còn mở và chưa bị force-cancel
+}
+
+// consumer một consumer đang chạy trên channel riêng
+type consumer struct {
+	tag     string
+	queue   string
+	opts    ConsumeOptions
+	handler func(amqp.Delivery)
+	logger  Logger
+	channel amqpChannel
+	qos     QoSProfile
+
+	deliveries <-chan amqp.Delivery
+	workers    chan struct{} // Được đóng khi các worker của lần subscribe hiện tại đã dừng
+	mu         sync.Mutex
+	pending    map[uint64]amqp.Delivery // Delivery đã nhận nhưng handler chưa xử lý xong
+	forced     bool                     // Consumer đã bị force-cancel, delivery mới bị nack-requeue
+	buffers    []chan amqp.Delivery     // Hàng đợi của từng worker khi dùng OrderByKey
+	checkpoint *checkpoint              // Khác nil khi opts.Checkpointer được cấu hình
+
+	stopped  chan struct{} // Được đóng khi consumer bị hủy
+	stopOnce sync.Once
+}
+
+var consumerSeq uint64
+
+// nextConsumerTag sinh consumer tag duy nhất trong process
+func nextConsumerTag() string {
+	return fmt.Sprintf("bunnyhop-%d", atomic.AddUint64(&consumerSeq, 1))
+}
+
+// Consume đăng ký consumer trên queue, mỗi delivery được chuyển cho handler
+// trong các worker goroutine. Mỗi consumer dùng channel riêng.
+func (c *Client) Consume(queue string, opts ConsumeOptions, handler func(amqp.Delivery)) error {
+	_, err := c.consume(queue, opts, handler)
+	return err
+}
+
+// consume đăng ký consumer và trả về consumer đã tạo
+func (c *Client) consume(queue string, opts ConsumeOptions, handler func(amqp.Delivery)) (*consumer, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler is required")
+	}
+	if opts.ConsumerTag == "" {
+		opts.ConsumerTag = nextConsumerTag()
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Checkpointer != nil && opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = defaultCheckpointInterval
+	}
+
+	profile, hasProfile := c.config.QoSProfiles[opts.QoSProfile]
+	if opts.QoSProfile != "" && !hasProfile {
+		return nil, fmt.Errorf("unknown QoS profile: %s", opts.QoSProfile)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	qos := c.defaultConsumerQoS()
+	if hasProfile {
+		qos = profile
+	}
+
+	if !c.connected || c.connection == nil || c.connection.IsClosed() {
+		return nil, fmt.Errorf("client is not connected")
+	}
+	if _, exists := c.consumers[opts.ConsumerTag]; exists {
+		return nil, fmt.Errorf("consumer %s already exists", opts.ConsumerTag)
+	}
+
+	cons := &consumer{
+		tag:     opts.ConsumerTag,
+		queue:   queue,
+		opts:    opts,
+		handler: handler,
+		logger:  c.logger(),
+		qos:     qos,
+		pending: make(map[uint64]amqp.Delivery),
+		stopped: make(chan struct{}),
+	}
+	if opts.Checkpointer != nil {
+		cons.checkpoint = &checkpoint{}
+	}
+	if err := c.subscribe(cons); err != nil {
+		return nil, err
+	}
+	c.consumers[cons.tag] = cons
+
+	if c.config.Purpose == PurposePublisher {
+		c.logger().Warn("Consumer %s started on a publisher connection", cons.tag)
+	}
+	c.logger().Info("Started consumer %s on queue %s with %d workers", cons.tag, queue, opts.Workers)
+	return cons, nil
+}
+
+// defaultConsumerQoS trả về QoS của consumer không chọn QoSProfile: QoS đặt qua
+// SetQos, nếu không thì prefetch trong Config. Phải giữ c.mutex
+func (c *Client) defaultConsumerQoS() QoSProfile {
+	if c.qos != nil {
+		return *c.qos
+	}
+	return QoSProfile{
+		PrefetchCount: c.config.PrefetchCount,
+		PrefetchSize:  c.config.PrefetchSize,
+		Global:        c.config.GlobalQos,
+	}
+}
+
+// subscribe mở channel mới cho consumer, gửi basic.consume và chạy các worker.
+// Phải giữ c.mutex và các worker của lần subscribe trước phải đã dừng
+func (c *Client) subscribe(cons *consumer) error {
+	ch, err := c.connection.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open consumer channel: %v", err)
+	}
+	ch, err = c.applyQoS(c.connection, ch, cons.qos)
+	if err != nil {
+		return fmt.Errorf("failed to set consumer QoS: %v", err)
+	}
+
+	args := cons.opts.Args
+	if cons.opts.Checkpointer != nil {
+		args, err = resumeArgs(cons.opts.Checkpointer, cons.queue, cons.tag, cons.opts.Args)
+		if err != nil {
+			ch.Close()
+			return err
+		}
+	}
+
+	deliveries, err := ch.Consume(cons.queue, cons.tag, cons.opts.AutoAck, cons.opts.Exclusive, false, false, args)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to consume from %s: %v", cons.queue, err)
+	}
+
+	cons.mu.Lock()
+	cons.channel = ch
+	cons.deliveries = deliveries
+	cons.mu.Unlock()
+
+	workers := c.startConsumerWorkers(cons, deliveries, cons.qos.PrefetchCount)
+	cons.mu.Lock()
+	cons.workers = workers
+	cons.mu.Unlock()
+
+	go c.watchConsumerChannel(cons, ch.NotifyClose(make(chan *amqp.Error, 1)), workers)
+	if cons.checkpoint != nil {
+		go cons.runCheckpoints(cons.opts.CheckpointInterval, workers)
+	}
+	return nil
+}
+
+// workersDone trả về channel được đóng khi các worker của lần subscribe hiện
+// tại đã dừng. Mỗi lần subscribe có channel riêng nên người chờ lần trước không
+// bị ảnh hưởng khi consumer được đăng ký lại
+func (cons *consumer) workersDone() <-chan struct{} {
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+	return cons.workers
+}
+
+// resubscribe đăng ký lại consumer sau khi channel của nó bị đóng. Các worker
+// cũ (đóng workers khi dừng) được chờ xử lý xong delivery đã nhận trước. Nếu
+// connection đang mất, reconnect sẽ đăng ký lại sau khi kết nối thành công
+func (c *Client) resubscribe(cons *consumer, workers <-chan struct{}) {
+	<-workers
+	if cons.checkpoint != nil {
+		cons.storeCheckpoint()
+	}
+
+	for {
+		c.mutex.Lock()
+		if c.ctx.Err() != nil || c.consumers[cons.tag] != cons ||
+			!c.connected || c.connection == nil || c.connection.IsClosed() {
+			c.mutex.Unlock()
+			return
+		}
+		if !cons.channel.IsClosed() {
+			// Đã được đăng ký lại bởi lần resubscribe khác
+			c.mutex.Unlock()
+			return
+		}
+		err := c.subscribe(cons)
+		c.mutex.Unlock()
+
+		if err == nil {
+			c.logger().Info("Resubscribed consumer %s on queue %s", cons.tag, cons.queue)
+			return
+		}
+		c.logger().Error("Failed to resubscribe consumer %s: %v", cons.tag, err)
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(reconnectDelay(c.config.ReconnectInterval, c.config.MinReconnectInterval)):
+		}
+	}
+}
+
+// resubscribeConsumers đăng ký lại mọi consumer sau khi reconnect thành công
+func (c *Client) resubscribeConsumers() {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, cons := range c.consumers {
+		go c.resubscribe(cons, cons.workersDone())
+	}
+}
+
+// ConsumeWithAck đăng ký consumer với handler trả lỗi: delivery được ack khi
+// handler trả nil và nack theo opts.AckPolicy khi handler trả lỗi. ctx của handler
+// bị hủy khi client đóng.
+func (c *Client) ConsumeWithAck(queue string, opts ConsumeOptions, handler func(ctx context.Context, d amqp.Delivery) error) error {
+	if handler == nil {
+		return fmt.Errorf("handler is required")
+	}
+	if opts.AutoAck {
+		return fmt.Errorf("ConsumeWithAck requires manual acknowledgement, AutoAck must be false")
+	}
+
+	logger := c.logger()
+	return c.Consume(queue, opts, func(d amqp.Delivery) {
+		if err := handler(c.ctx, d); err != nil {
+			requeue := opts.AckPolicy == NackRequeue
+			logger.Warn("Handler failed on delivery %d from %s, nack (requeue=%v): %v", d.DeliveryTag, queue, requeue, err)
+			if err := d.Nack(false, requeue); err != nil {
+				logger.Error("Failed to nack delivery %d: %v", d.DeliveryTag, err)
+			}
+			return
+		}
+		if err := d.Ack(false); err != nil {
+			logger.Error("Failed to ack delivery %d: %v", d.DeliveryTag, err)
+		}
+	})
+}
+
+// ConsumeChannel đăng ký consumer và trả về channel các giá trị T được giải mã
+// từ body bằng Codec của client. Delivery giải mã thành công được ack khi giá
+// trị được đọc khỏi channel (trừ khi opts.AutoAck), giải mã lỗi thì bị nack
+// không requeue. Channel trả về bị đóng sau khi consumer bị hủy (CancelConsumer,
+// StopConsumers hoặc Close); delivery chưa được đọc khi đó bị nack-requeue.
+func ConsumeChannel[T any](c *Client, queue string, opts ConsumeOptions) (<-chan T, error) {
+	if opts.ConsumerTag == "" {
+		opts.ConsumerTag = nextConsumerTag()
+	}
+
+	out := make(chan T)
+	stopped := make(chan struct{})
+	logger := c.logger()
+	cons, err := c.consume(queue, opts, func(d amqp.Delivery) {
+		var v T
+		if err := c.config.Codec.Unmarshal(d.Body, &v); err != nil {
+			logger.Error("Failed to decode delivery %d from %s: %v", d.DeliveryTag, queue, err)
+			if !opts.AutoAck {
+				if err := d.Nack(false, false); err != nil {
+					logger.Error("Failed to nack delivery %d: %v", d.DeliveryTag, err)
+				}
+			}
+			return
+		}
+
+		select {
+		case out <- v:
+			if !opts.AutoAck {
+				if err := d.Ack(false); err != nil {
+					logger.Error("Failed to ack delivery %d: %v", d.DeliveryTag, err)
+				}
+			}
+		case <-stopped:
+			if !opts.AutoAck {
+				d.Nack(false, true)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-cons.stopped
+		close(stopped)
+		// Consumer đã bị xóa khỏi client nên không còn lần subscribe mới
+		<-cons.workersDone()
+		close(out)
+	}()
+	return out, nil
+}
+
+// ConsumeN consume đúng n message từ queue rồi hủy consumer và trả về. Delivery
+// vượt quá n được nack-requeue. Delivery làm handler panic vẫn được tính và bị
+// nack theo PanicPolicy, khi đó ConsumeN trả lỗi kèm số lần panic. Nếu
+// opts.Timeout hết hạn trước khi đủ n message, trả về lỗi kèm số message đã xử lý.
+func (c *Client) ConsumeN(queue string, n int, opts ConsumeOptions, handler func(amqp.Delivery)) error {
+	if n <= 0 {
+		return fmt.Errorf("n must be positive")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler is required")
+	}
+	if opts.AutoAck {
+		return fmt.Errorf("ConsumeN does not support AutoAck")
+	}
+	if opts.ConsumerTag == "" {
+		opts.ConsumerTag = nextConsumerTag()
+	}
+
+	var claimed, processed, panicked int64
+	done := make(chan struct{})
+	err := c.Consume(queue, opts, func(d amqp.Delivery) {
+		if atomic.AddInt64(&claimed, 1) > int64(n) {
+			d.Nack(false, true)
+			return
+		}
+		// Đếm cả khi handler panic, panic được worker recover và nack sau đó
+		completed := false
+		defer func() {
+			if !completed {
+				atomic.AddInt64(&panicked, 1)
+			}
+			if atomic.AddInt64(&processed, 1) == int64(n) {
+				close(done)
+			}
+		}()
+		handler(d)
+		completed = true
+	})
+	if err != nil {
+		return err
+	}
+	defer c.CancelConsumer(opts.ConsumerTag)
+
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+		if failed := atomic.LoadInt64(&panicked); failed > 0 {
+			return fmt.Errorf("handler panicked on %d of %d messages from %s", failed, n, queue)
+		}
+		return nil
+	case <-timeout:
+		return fmt.Errorf("consumed %d of %d messages from %s before timeout", atomic.LoadInt64(&processed), n, queue)
+	case <-c.ctx.Done():
+		return fmt.Errorf("client closed after consuming %d of %d messages from %s", atomic.LoadInt64(&processed), n, queue)
+	}
+}
+
+// CancelConsumer hủy một consumer, chờ handler xử lý xong rồi đóng channel của nó
+func (c *Client) CancelConsumer(tag string) error {
+	c.mutex.Lock()
+	cons, ok := c.consumers[tag]
+	delete(c.consumers, tag)
+	c.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("consumer not found: %s", tag)
+	}
+	cons.stop()
+
+	if err := cons.channel.Cancel(cons.tag, false); err != nil {
+		c.logger().Debug("Failed to cancel consumer %s: %v", cons.tag, err)
+	}
+	<-cons.workersDone()
+	cons.channel.Close()
+	return nil
+}
+
+// Consumers trả về danh sách consumer đang đăng ký, sắp xếp theo tag
+func (c *Client) Consumers() []ConsumerInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	infos := make([]ConsumerInfo, 0, len(c.consumers))
+	for _, cons := range c.consumers {
+		cons.mu.Lock()
+		forced := cons.forced
+		cons.mu.Unlock()
+		infos = append(infos, ConsumerInfo{
+			Tag:      cons.tag,
+			Queue:    cons.queue,
+			Prefetch: cons.qos.PrefetchCount,
+			Workers:  cons.opts.Workers,
+			Running:  !forced && !cons.channel.IsClosed(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tag < infos[j].Tag })
+	return infos
+}
+
+// startConsumerWorkers chạy các worker xử lý delivery của consumer, channel
+// trả về được đóng khi mọi worker đã dừng
+func (c *Client) startConsumerWorkers(cons *consumer, deliveries <-chan amqp.Delivery, prefetch int) chan struct{} {
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	// Chạy sau khi mọi worker đã được Add
+	defer func() {
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+	}()
+
+	if cons.opts.OrderByKey == nil {
+		for i := 0; i < cons.opts.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for d := range deliveries {
+					if cons.receive(d) {
+						cons.process(d)
+					}
+				}
+			}()
+		}
+		return done
+	}
+
+	// Mỗi worker có hàng đợi riêng, delivery được chia theo hash của key
+	queues := make([]chan amqp.Delivery, cons.opts.Workers)
+	bufferSize := workerBufferSize(cons.opts.DeliveryBuffer, prefetch, len(queues))
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery, bufferSize)
+		wg.Add(1)
+		go func(queue chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range queue {
+				cons.process(d)
+			}
+		}(queues[i])
+	}
+	cons.buffers = queues
+
+	go func() {
+		defer func() {
+			for _, queue := range queues {
+				close(queue)
+			}
+		}()
+		for d := range deliveries {
+			if !cons.receive(d) {
+				continue
+			}
+			queues[orderedWorker(cons.opts.OrderByKey(d), len(queues))] <- d
+		}
+	}()
+	return done
+}
+
+// workerBufferSize kích thước hàng đợi của mỗi worker để tổng không vượt
+// DeliveryBuffer (mặc định prefetch), tối thiểu 1
+func workerBufferSize(deliveryBuffer, prefetch, workers int) int {
+	if deliveryBuffer <= 0 {
+		deliveryBuffer = prefetch
+	}
+	size := deliveryBuffer / workers
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// buffered số delivery đang chờ trong các hàng đợi nội bộ
+func (cons *consumer) buffered() int {
+	total := 0
+	for _, queue := range cons.buffers {
+		total += len(queue)
+	}
+	return total
+}
+
+// receive ghi nhận delivery vào pending, trả về false nếu consumer đã bị
+// force-cancel (delivery được nack-requeue)
+func (cons *consumer) receive(d amqp.Delivery) bool {
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+
+	if cons.forced {
+		cons.requeue(d)
+		return false
+	}
+	cons.pending[d.DeliveryTag] = d
+	return true
+}
+
+// process chạy handler cho delivery chưa bị force-cancel
+func (cons *consumer) process(d amqp.Delivery) {
+	cons.mu.Lock()
+	_, ok := cons.pending[d.DeliveryTag]
+	cons.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if cons.invoke(d) && cons.checkpoint != nil {
+		cons.checkpoint.record(d)
+	}
+
+	cons.mu.Lock()
+	delete(cons.pending, d.DeliveryTag)
+	cons.mu.Unlock()
+}
+
+// invoke gọi handler, panic được recover và delivery được xử lý theo PanicPolicy
+// để worker tiếp tục chạy. Trả về false nếu handler panic.
+func (cons *consumer) invoke(d amqp.Delivery) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		ok = false
+		cons.logger.Error("Consumer %s handler panicked on delivery %d: %v", cons.tag, d.DeliveryTag, r)
+		if cons.opts.AutoAck {
+			return
+		}
+		requeue := cons.opts.PanicPolicy == PanicRequeue
+		if err := cons.channel.Nack(d.DeliveryTag, false, requeue); err != nil {
+			cons.logger.Error("Failed to nack delivery %d after panic: %v", d.DeliveryTag, err)
+		}
+	}()
+	cons.handler(d)
+	return true
+}
+
+// requeue nack-requeue delivery, phải giữ cons.mu
+func (cons *consumer) requeue(d amqp.Delivery) {
+	if cons.opts.AutoAck {
+		return
+	}
+	cons.channel.Nack(d.DeliveryTag, false, true)
+}
+
+// forceCancel nack-requeue các delivery đang xử lý dở và đang chờ trong buffer,
+// trả về số delivery bị force-cancel
+func (cons *consumer) forceCancel() int {
+	cons.mu.Lock()
+	defer cons.mu.Unlock()
+
+	cons.forced = true
+	cancelled := 0
+	for tag, d := range cons.pending {
+		cons.requeue(d)
+		delete(cons.pending, tag)
+		cancelled++
+	}
+
+	for {
+		select {
+		case d, ok := <-cons.deliveries:
+			if !ok {
+				return cancelled
+			}
+			cons.requeue(d)
+			cancelled++
+		default:
+			return cancelled
+		}
+	}
+}
+
+// StopConsumers hủy mọi consumer và chờ handler xử lý xong tối đa DrainTimeout.
+// Quá hạn, các delivery còn lại bị nack-requeue; trả về số delivery bị force-cancel.
+func (c *Client) StopConsumers() int {
+	c.mutex.Lock()
+	consumers := make([]*consumer, 0, len(c.consumers))
+	for tag, cons := range c.consumers {
+		consumers = append(consumers, cons)
+		delete(c.consumers, tag)
+		cons.stop()
+	}
+	c.mutex.Unlock()
+
+	if len(consumers) == 0 {
+		return 0
+	}
+
+	// Ngừng nhận delivery mới, các delivery đã nhận vẫn được xử lý
+	for _, cons := range consumers {
+		if err := cons.channel.Cancel(cons.tag, false); err != nil {
+			c.logger().Debug("Failed to cancel consumer %s: %v", cons.tag, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, cons := range consumers {
+			<-cons.workersDone()
+		}
+		close(done)
+	}()
+
+	timer := time.NewTimer(c.config.DrainTimeout)
+	defer timer.Stop()
+
+	cancelled := 0
+	select {
+	case <-done:
+	case <-timer.C:
+		for _, cons := range consumers {
+			cancelled += cons.forceCancel()
+		}
+	}
+	if cancelled > 0 {
+		c.logger().Warn("Consumer drain timed out after %v, force-cancelled %d deliveries", c.config.DrainTimeout, cancelled)
+	}
+
+	for _, cons := range consumers {
+		cons.channel.Close()
+	}
+	return cancelled
+}
+
+// stop đánh dấu consumer đã bị hủy, gọi sau khi consumer được xóa khỏi client
+func (cons *consumer) stop() {
+	cons.stopOnce.Do(func() { close(cons.stopped) })
+}
+
+// orderedWorker chọn worker cho một key
+func orderedWorker(key string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// watchConsumerChannel báo lỗi khi channel của consumer bị đóng bất thường
+func (c *Client) watchConsumerChannel(cons *consumer, closes chan *amqp.Error, workers <-chan struct{}) {
+	err, ok := <-closes
+	if !ok || err == nil {
+		return
+	}
+
+	c.logger().Error("Consumer %s channel closed: %v", cons.tag, err)
+	c.recordCloseError(err, true)
+	c.notifyChannelClose(err)
+
+	// Connection vẫn còn thì đăng ký lại ngay, nếu không reconnect sẽ làm việc này
+	c.mutex.RLock()
+	connectionOpen := c.connected && c.connection != nil && !c.connection.IsClosed()
+	c.mutex.RUnlock()
+	if !connectionOpen {
+		return
+	}
+	select {
+	case <-c.ctx.Done():
+		return
+	case <-time.After(reconnectDelay(c.config.ReconnectInterval, c.config.MinReconnectInterval)):
+	}
+	c.resubscribe(cons, workers)
+}
+
+// notifyChannelClose gọi callback OnChannelClose nếu được cấu hình
+func (c *Client) notifyChannelClose(err *amqp.Error) {
+	if c.config.OnChannelClose != nil {
+		c.config.OnChannelClose(err)
+	}
+}
+
+// recordCloseError báo mã lỗi đóng cho pool. Lỗi của channel bị đóng kéo
+// theo connection (cùng mã lỗi) không được tính thêm lần nữa
+func (c *Client) recordCloseError(err *amqp.Error, channel bool) {
+	if c.config.onCloseError == nil {
+		return
+	}
+	if channel {
+		c.mutex.RLock()
+		connectionClosed := c.connection == nil || c.connection.IsClosed()
+		c.mutex.RUnlock()
+		if connectionClosed {
+			return
+		}
+	}
+	c.config.onCloseError(err)
+}
+
+// closeConsumerChannels đóng channel của mọi consumer nhưng giữ đăng ký để
+// resubscribeConsumers đăng ký lại, phải giữ c.mutex
+func (c *Client) closeConsumerChannels() {
+	for _, cons := range c.consumers {
+		cons.channel.Close()
+	}
+}
+
+// closeConsumers đóng channel của mọi consumer, phải giữ c.mutex
+func (c *Client) closeConsumers() {
+	for tag, cons := range c.consumers {
+		cons.channel.Close()
+		delete(c.consumers, tag)
+		cons.stop()
+	}
+}
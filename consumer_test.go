@@ -0,0 +1,654 @@
+package bunnyhop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumer_ResubscribesAfterChannelClose(t *testing.T) {
+	client, dialer := newFakeClient(Config{
+		ReconnectInterval:    time.Millisecond,
+		MinReconnectInterval: time.Millisecond,
+		Logger:               &captureLogger{},
+	})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	received := make(chan amqp.Delivery, 1)
+	err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "worker", Workers: 2}, func(d amqp.Delivery) {
+		received <- d
+	})
+	require.NoError(t, err)
+
+	conn := dialer.last()
+	conn.channel(1).closeWithError(&amqp.Error{Code: amqp.InternalError, Reason: "INTERNAL_ERROR"})
+
+	var resumed *fakeChannel
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if len(conn.channels) < 3 {
+			return false
+		}
+		resumed = conn.channels[2]
+		return resumed.hasConsumer("worker")
+	}, time.Second, 5*time.Millisecond)
+
+	resumed.deliver("worker", amqp.Delivery{Body: []byte("after")})
+	select {
+	case d := <-received:
+		assert.Equal(t, []byte("after"), d.Body)
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not resume after channel close")
+	}
+
+	infos := client.Consumers()
+	require.Len(t, infos, 1)
+	assert.True(t, infos[0].Running)
+	assert.Same(t, conn, dialer.last(), "connection should not be replaced")
+}
+
+func TestConsumer_WaitersSurviveRepeatedResubscribes(t *testing.T) {
+	client, dialer := newFakeClient(Config{
+		ReconnectInterval:    time.Millisecond,
+		MinReconnectInterval: time.Millisecond,
+		Logger:               &captureLogger{},
+	})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	checkpointer := NewMemoryCheckpointer()
+	values, err := ConsumeChannel[int](client, "events", ConsumeOptions{
+		ConsumerTag:        "reader",
+		Workers:            2,
+		Checkpointer:       checkpointer,
+		CheckpointInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// Mỗi lần subscribe lại có worker mới, các goroutine chờ lần trước không
+	// được dùng chung WaitGroup với lần sau
+	conn := dialer.last()
+	for i := 1; i <= 3; i++ {
+		current := conn.channel(i)
+		current.deliver("reader", amqp.Delivery{Body: []byte(fmt.Sprint(i)), Headers: amqp.Table{"x-stream-offset": int64(i)}})
+		select {
+		case v := <-values:
+			assert.Equal(t, i, v)
+		case <-time.After(time.Second):
+			t.Fatalf("delivery %d not dispatched", i)
+		}
+		current.closeWithError(&amqp.Error{Code: amqp.InternalError, Reason: "INTERNAL_ERROR"})
+		require.Eventually(t, func() bool {
+			conn.mu.Lock()
+			defer conn.mu.Unlock()
+			return len(conn.channels) > i+1 && conn.channels[i+1].hasConsumer("reader")
+		}, time.Second, 5*time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		offset, ok, _ := checkpointer.LoadOffset("events", "reader")
+		return ok && offset == 3
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, client.CancelConsumer("reader"))
+	select {
+	case _, ok := <-values:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after CancelConsumer")
+	}
+}
+
+func TestConsumer_DefaultQoSFollowsClientConfig(t *testing.T) {
+	client, dialer := newFakeClient(Config{PrefetchCount: 25, PrefetchSize: 4096, GlobalQos: true})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	require.NoError(t, client.Consume("jobs", ConsumeOptions{ConsumerTag: "config"}, func(amqp.Delivery) {}))
+	assert.Equal(t, []fakeQos{{PrefetchCount: 25, PrefetchSize: 4096, Global: true}}, dialer.last().channel(1).qos)
+
+	// QoS đặt qua SetQos được dùng cho consumer tạo sau đó
+	require.NoError(t, client.SetQos(7, 0, false))
+	require.NoError(t, client.Consume("jobs", ConsumeOptions{ConsumerTag: "set-qos"}, func(amqp.Delivery) {}))
+	assert.Equal(t, []fakeQos{{PrefetchCount: 7}}, dialer.last().channel(2).qos)
+
+	infos := client.Consumers()
+	require.Len(t, infos, 2)
+	assert.Equal(t, 25, infos[0].Prefetch)
+	assert.Equal(t, 7, infos[1].Prefetch)
+}
+
+func TestConsumer_OnChannelCloseReceivesError(t *testing.T) {
+	closed := make(chan *amqp.Error, 1)
+	client, dialer := newFakeClient(Config{
+		OnChannelClose: func(err *amqp.Error) { closed <- err },
+	})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	received := make(chan amqp.Delivery, 1)
+	err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "worker"}, func(d amqp.Delivery) {
+		received <- d
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("worker", amqp.Delivery{Body: []byte("hello")})
+	select {
+	case d := <-received:
+		assert.Equal(t, []byte("hello"), d.Body)
+	case <-time.After(time.Second):
+		t.Fatal("delivery not dispatched to handler")
+	}
+
+	consumerCh.closeWithError(&amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED"})
+
+	select {
+	case err := <-closed:
+		assert.Equal(t, amqp.PreconditionFailed, err.Code)
+	case <-time.After(time.Second):
+		t.Fatal("OnChannelClose was not called")
+	}
+
+	// Channel của consumer lỗi không làm mất connection
+	assert.True(t, client.IsConnected())
+}
+
+func TestConsumer_OrderByKeySerializesSameKey(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	const workers = 4
+	keyA := "tenant-a"
+	keyB := "tenant-b"
+	for i := 0; orderedWorker(keyB, workers) == orderedWorker(keyA, workers); i++ {
+		keyB = fmt.Sprintf("tenant-b-%d", i)
+	}
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	maxActive := map[string]int{}
+	maxTotal, total := 0, 0
+	var processed sync.WaitGroup
+
+	err := client.Consume("jobs", ConsumeOptions{
+		ConsumerTag: "ordered",
+		Workers:     workers,
+		OrderByKey:  func(d amqp.Delivery) string { return d.RoutingKey },
+	}, func(d amqp.Delivery) {
+		defer processed.Done()
+		mu.Lock()
+		active[d.RoutingKey]++
+		total++
+		if active[d.RoutingKey] > maxActive[d.RoutingKey] {
+			maxActive[d.RoutingKey] = active[d.RoutingKey]
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active[d.RoutingKey]--
+		total--
+		mu.Unlock()
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	for i := 0; i < 5; i++ {
+		processed.Add(2)
+		consumerCh.deliver("ordered", amqp.Delivery{RoutingKey: keyA})
+		consumerCh.deliver("ordered", amqp.Delivery{RoutingKey: keyB})
+	}
+	processed.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, maxActive[keyA])
+	assert.Equal(t, 1, maxActive[keyB])
+	assert.Equal(t, 2, maxTotal, "different keys should run concurrently")
+}
+
+func TestConsumer_StopConsumersForceCancelsAfterDrainTimeout(t *testing.T) {
+	client, dialer := newFakeClient(Config{DrainTimeout: 50 * time.Millisecond})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "slow"}, func(d amqp.Delivery) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("slow", amqp.Delivery{Body: []byte("stuck")})
+	consumerCh.deliver("slow", amqp.Delivery{Body: []byte("buffered")})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not started")
+	}
+
+	begin := time.Now()
+	cancelled := client.StopConsumers()
+	assert.Less(t, time.Since(begin), time.Second)
+
+	assert.Equal(t, 2, cancelled)
+	assert.ElementsMatch(t, []fakeAck{{Tag: 1, Requeue: true}, {Tag: 2, Requeue: true}}, consumerCh.ackList())
+	assert.False(t, consumerCh.hasConsumer("slow"))
+	assert.True(t, consumerCh.IsClosed())
+}
+
+func TestConsumer_StopConsumersWaitsForHandlers(t *testing.T) {
+	client, dialer := newFakeClient(Config{DrainTimeout: time.Second})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	var handled sync.WaitGroup
+	handled.Add(1)
+	err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "quick"}, func(d amqp.Delivery) {
+		time.Sleep(20 * time.Millisecond)
+		d.Ack(false)
+		handled.Done()
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("quick", amqp.Delivery{Body: []byte("job")})
+
+	assert.Equal(t, 0, client.StopConsumers())
+	handled.Wait()
+	assert.Equal(t, []fakeAck{{Tag: 1, Ack: true}}, consumerCh.ackList())
+}
+
+func TestConsumer_CloseWaitsForRunningHandlerByDefault(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+
+	started := make(chan struct{})
+	err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "busy"}, func(d amqp.Delivery) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		d.Ack(false)
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("busy", amqp.Delivery{Body: []byte("job")})
+	<-started
+
+	// DrainTimeout không cấu hình: Close chờ handler ack thay vì force-cancel ngay
+	require.NoError(t, client.Close())
+	assert.Equal(t, []fakeAck{{Tag: 1, Ack: true}}, consumerCh.ackList())
+}
+
+func TestConsumer_ConsumeNCountsPanickingHandler(t *testing.T) {
+	client, dialer := newFakeClient(Config{Logger: &captureLogger{}})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.ConsumeN("jobs", 2, ConsumeOptions{ConsumerTag: "batch"}, func(d amqp.Delivery) {
+			if string(d.Body) == "bad" {
+				panic("boom")
+			}
+			d.Ack(false)
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		ch := dialer.last().channel(1)
+		return ch != nil && ch.hasConsumer("batch")
+	}, time.Second, time.Millisecond)
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("batch", amqp.Delivery{Body: []byte("bad")})
+	consumerCh.deliver("batch", amqp.Delivery{Body: []byte("good")})
+
+	select {
+	case err := <-result:
+		assert.ErrorContains(t, err, "handler panicked on 1 of 2 messages")
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeN did not return after a handler panic")
+	}
+	assert.ElementsMatch(t, []fakeAck{{Tag: 1, Requeue: true}, {Tag: 2, Ack: true}}, consumerCh.ackList())
+}
+
+func TestConsumer_ConsumeNStopsAfterExactlyN(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	var mu sync.Mutex
+	var bodies []string
+	delivered := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- client.ConsumeN("jobs", 3, ConsumeOptions{ConsumerTag: "batch"}, func(d amqp.Delivery) {
+			<-delivered
+			mu.Lock()
+			bodies = append(bodies, string(d.Body))
+			mu.Unlock()
+			d.Ack(false)
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		ch := dialer.last().channel(1)
+		return ch != nil && ch.hasConsumer("batch")
+	}, time.Second, time.Millisecond)
+	consumerCh := dialer.last().channel(1)
+	for i := 1; i <= 5; i++ {
+		consumerCh.deliver("batch", amqp.Delivery{Body: []byte(fmt.Sprintf("m%d", i))})
+	}
+	close(delivered)
+
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeN did not return")
+	}
+
+	assert.Equal(t, []string{"m1", "m2", "m3"}, bodies)
+	assert.Equal(t, []fakeAck{
+		{Tag: 1, Ack: true}, {Tag: 2, Ack: true}, {Tag: 3, Ack: true},
+		{Tag: 4, Requeue: true}, {Tag: 5, Requeue: true},
+	}, consumerCh.ackList())
+	assert.False(t, consumerCh.hasConsumer("batch"))
+	assert.True(t, consumerCh.IsClosed())
+}
+
+func TestConsumer_ConsumeNTimeout(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.ConsumeN("jobs", 3, ConsumeOptions{ConsumerTag: "batch", Timeout: 50 * time.Millisecond},
+			func(d amqp.Delivery) { d.Ack(false) })
+	}()
+
+	require.Eventually(t, func() bool {
+		ch := dialer.last().channel(1)
+		return ch != nil && ch.hasConsumer("batch")
+	}, time.Second, time.Millisecond)
+	dialer.last().channel(1).deliver("batch", amqp.Delivery{Body: []byte("only")})
+
+	select {
+	case err := <-result:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "consumed 1 of 3")
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeN did not time out")
+	}
+}
+
+func TestConsumer_WarnsOnUnlimitedPrefetch(t *testing.T) {
+	logger := &captureLogger{}
+	client, _ := newFakeClient(Config{
+		Logger:      logger,
+		QoSProfiles: map[string]QoSProfile{"unlimited": {PrefetchCount: 0}},
+	})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+	assert.Empty(t, logger.find("WARN", "prefetch is 0"))
+
+	require.NoError(t, client.Consume("jobs", ConsumeOptions{QoSProfile: "unlimited"}, func(amqp.Delivery) {}))
+	assert.Len(t, logger.find("WARN", "prefetch is 0"), 1)
+
+	allowed := &captureLogger{}
+	client2, _ := newFakeClient(Config{
+		Logger:                 allowed,
+		QoSProfiles:            map[string]QoSProfile{"unlimited": {PrefetchCount: 0}},
+		AllowUnlimitedPrefetch: true,
+	})
+	require.NoError(t, client2.Connect(context.Background()))
+	defer client2.Close()
+	require.NoError(t, client2.Consume("jobs", ConsumeOptions{QoSProfile: "unlimited"}, func(amqp.Delivery) {}))
+	assert.Empty(t, allowed.find("WARN", "prefetch is 0"))
+}
+
+func TestConsumer_ConsumersListsRegistered(t *testing.T) {
+	client, dialer := newFakeClient(Config{
+		QoSProfiles: map[string]QoSProfile{"bulk": {PrefetchCount: 50}},
+	})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	handler := func(amqp.Delivery) {}
+	require.NoError(t, client.Consume("emails", ConsumeOptions{ConsumerTag: "a-emails"}, handler))
+	require.NoError(t, client.Consume("reports", ConsumeOptions{ConsumerTag: "b-reports", QoSProfile: "bulk", Workers: 4}, handler))
+
+	assert.Equal(t, []ConsumerInfo{
+		{Tag: "a-emails", Queue: "emails", Prefetch: 1, Workers: 1, Running: true},
+		{Tag: "b-reports", Queue: "reports", Prefetch: 50, Workers: 4, Running: true},
+	}, client.Consumers())
+
+	// Channel bị broker đóng thì consumer không còn chạy
+	dialer.last().channel(1).closeWithError(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND"})
+	assert.False(t, client.Consumers()[0].Running)
+
+	require.NoError(t, client.CancelConsumer("b-reports"))
+	assert.Error(t, client.CancelConsumer("b-reports"))
+	infos := client.Consumers()
+	require.Len(t, infos, 1)
+	assert.Equal(t, "a-emails", infos[0].Tag)
+}
+
+func TestConsumer_HandlerPanicRequeuesAndKeepsRunning(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  PanicPolicy
+		requeue bool
+	}{
+		{"requeue", PanicRequeue, true},
+		{"dead letter", PanicDeadLetter, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, dialer := newFakeClient(Config{Logger: &captureLogger{}})
+			require.NoError(t, client.Connect(context.Background()))
+			defer client.Close()
+
+			handled := make(chan string, 2)
+			err := client.Consume("jobs", ConsumeOptions{ConsumerTag: "panicky", PanicPolicy: tc.policy}, func(d amqp.Delivery) {
+				if string(d.Body) == "boom" {
+					panic("handler exploded")
+				}
+				d.Ack(false)
+				handled <- string(d.Body)
+			})
+			require.NoError(t, err)
+
+			consumerCh := dialer.last().channel(1)
+			consumerCh.deliver("panicky", amqp.Delivery{Body: []byte("boom")})
+			consumerCh.deliver("panicky", amqp.Delivery{Body: []byte("ok")})
+
+			select {
+			case body := <-handled:
+				assert.Equal(t, "ok", body)
+			case <-time.After(time.Second):
+				t.Fatal("consumer stopped after handler panic")
+			}
+			assert.Equal(t, []fakeAck{{Tag: 1, Requeue: tc.requeue}, {Tag: 2, Ack: true}}, consumerCh.ackList())
+		})
+	}
+}
+
+func TestConsumer_StreamCheckpointPersistedAndResumed(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	checkpointer := NewMemoryCheckpointer()
+	opts := ConsumeOptions{
+		ConsumerTag:        "stream-reader",
+		Args:               amqp.Table{"x-priority": 1},
+		Checkpointer:       checkpointer,
+		CheckpointInterval: 10 * time.Millisecond,
+	}
+	processed := make(chan int64, 3)
+	handler := func(d amqp.Delivery) {
+		processed <- d.Headers["x-stream-offset"].(int64)
+	}
+	require.NoError(t, client.Consume("events", opts, handler))
+
+	// Chưa có checkpoint nên args được giữ nguyên
+	consumerCh := dialer.last().channel(1)
+	assert.Equal(t, amqp.Table{"x-priority": 1}, consumerCh.argsOf("stream-reader"))
+
+	for offset := int64(40); offset < 43; offset++ {
+		consumerCh.deliver("stream-reader", amqp.Delivery{Headers: amqp.Table{"x-stream-offset": offset}})
+		<-processed
+	}
+	require.Eventually(t, func() bool {
+		offset, ok, _ := checkpointer.LoadOffset("events", "stream-reader")
+		return ok && offset == 42
+	}, time.Second, 5*time.Millisecond)
+
+	// Subscribe lại tiếp tục từ offset sau checkpoint
+	require.NoError(t, client.CancelConsumer("stream-reader"))
+	require.NoError(t, client.Consume("events", opts, handler))
+	resumedCh := dialer.last().channel(2)
+	assert.Equal(t, amqp.Table{"x-priority": 1, "x-stream-offset": int64(43)}, resumedCh.argsOf("stream-reader"))
+	assert.Equal(t, amqp.Table{"x-priority": 1}, opts.Args)
+}
+
+func TestConsumer_ConsumeChannelDecodesTypedValues(t *testing.T) {
+	type order struct {
+		ID    int    `json:"id"`
+		Item  string `json:"item"`
+		Total int    `json:"total"`
+	}
+
+	client, dialer := newFakeClient(Config{Logger: &captureLogger{}})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	orders, err := ConsumeChannel[order](client, "orders", ConsumeOptions{ConsumerTag: "orders"})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	consumerCh.deliver("orders", amqp.Delivery{Body: []byte(`{"id":1,"item":"book","total":12}`)})
+	consumerCh.deliver("orders", amqp.Delivery{Body: []byte(`not json`)})
+	consumerCh.deliver("orders", amqp.Delivery{Body: []byte(`{"id":2,"item":"pen","total":3}`)})
+
+	for _, want := range []order{{1, "book", 12}, {2, "pen", 3}} {
+		select {
+		case got := <-orders:
+			assert.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("order %d not received", want.ID)
+		}
+	}
+	require.Eventually(t, func() bool { return len(consumerCh.ackList()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []fakeAck{{Tag: 1, Ack: true}, {Tag: 2}, {Tag: 3, Ack: true}}, consumerCh.ackList())
+
+	// Hủy consumer thì channel bị đóng, delivery chưa được đọc bị requeue
+	consumerCh.deliver("orders", amqp.Delivery{Body: []byte(`{"id":3}`)})
+	require.NoError(t, client.CancelConsumer("orders"))
+	select {
+	case _, ok := <-orders:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after consumer cancellation")
+	}
+	require.Len(t, consumerCh.ackList(), 4)
+	assert.Equal(t, fakeAck{Tag: 4, Requeue: true}, consumerCh.ackList()[3])
+}
+
+func TestConsumer_ConsumeWithAck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  AckPolicy
+		err     error
+		wantAck fakeAck
+	}{
+		{"ack on nil", NackRequeue, nil, fakeAck{Tag: 1, Ack: true}},
+		{"nack requeue on error", NackRequeue, errors.New("boom"), fakeAck{Tag: 1, Requeue: true}},
+		{"nack dead letter on error", NackDeadLetter, errors.New("boom"), fakeAck{Tag: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, dialer := newFakeClient(Config{Logger: &captureLogger{}})
+			require.NoError(t, client.Connect(context.Background()))
+			defer client.Close()
+
+			handled := make(chan struct{})
+			err := client.ConsumeWithAck("jobs", ConsumeOptions{ConsumerTag: "worker", AckPolicy: tc.policy},
+				func(ctx context.Context, d amqp.Delivery) error {
+					defer close(handled)
+					assert.NoError(t, ctx.Err())
+					return tc.err
+				})
+			require.NoError(t, err)
+
+			consumerCh := dialer.last().channel(1)
+			consumerCh.deliver("worker", amqp.Delivery{Body: []byte("job")})
+			<-handled
+			require.Eventually(t, func() bool { return len(consumerCh.ackList()) == 1 }, time.Second, time.Millisecond)
+			assert.Equal(t, []fakeAck{tc.wantAck}, consumerCh.ackList())
+		})
+	}
+
+	client, _ := newFakeClient(Config{})
+	err := client.ConsumeWithAck("jobs", ConsumeOptions{AutoAck: true}, func(context.Context, amqp.Delivery) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestConsumer_DeliveryBufferIsBounded(t *testing.T) {
+	assert.Equal(t, 10, workerBufferSize(0, 10, 1))
+	assert.Equal(t, 2, workerBufferSize(5, 10, 2))
+	assert.Equal(t, 1, workerBufferSize(0, 0, 4))
+
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	release := make(chan struct{})
+	var handled sync.WaitGroup
+	handled.Add(10)
+	err := client.Consume("jobs", ConsumeOptions{
+		ConsumerTag:    "worker",
+		Workers:        1,
+		DeliveryBuffer: 3,
+		OrderByKey:     func(amqp.Delivery) string { return "same" },
+	}, func(amqp.Delivery) {
+		<-release
+		handled.Done()
+	})
+	require.NoError(t, err)
+
+	consumerCh := dialer.last().channel(1)
+	for i := 0; i < 10; i++ {
+		consumerCh.deliver("worker", amqp.Delivery{})
+	}
+
+	client.mutex.RLock()
+	cons := client.consumers["worker"]
+	client.mutex.RUnlock()
+
+	// Handler chậm: hàng đợi nội bộ đầy đến DeliveryBuffer rồi dừng lại
+	require.Eventually(t, func() bool { return cons.buffered() == 3 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 3, cons.buffered())
+
+	close(release)
+	handled.Wait()
+	assert.Zero(t, cons.buffered())
+}
@@ -0,0 +1,47 @@
+package bunnyhop
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Các helper trong file này cần plugin rabbitmq-message-deduplication
+// (https://github.com/noxdafox/rabbitmq-message-deduplication) được bật trên
+// broker. Không có plugin, header và x-argument bị broker bỏ qua và message
+// trùng vẫn được giao bình thường.
+
+const (
+	// DeduplicationHeader header chứa key dùng để phát hiện message trùng
+	DeduplicationHeader = "x-deduplication-header"
+	// queueDeduplicationArg bật deduplication ở mức queue
+	queueDeduplicationArg = "x-message-deduplication"
+)
+
+// PublishDeduplicated publish message với dedupKey trong header
+// x-deduplication-header, broker bỏ message có key đã thấy trước đó
+func (c *Client) PublishDeduplicated(exchange, routingKey, dedupKey string, msg amqp.Publishing) error {
+	if dedupKey == "" {
+		return fmt.Errorf("dedup key is required")
+	}
+
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[DeduplicationHeader] = dedupKey
+	msg.Headers = headers
+
+	return c.PublishMessage(exchange, routingKey, false, false, msg)
+}
+
+// DeclareDedupQueue khai báo queue durable có x-message-deduplication: true,
+// message có cùng x-deduplication-header với message đang nằm trong queue bị bỏ
+func (c *Client) DeclareDedupQueue(name string, opts QueueOptions) (amqp.Queue, error) {
+	args, err := opts.args()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	args[queueDeduplicationArg] = true
+	return c.DeclareQueue(name, true, opts.AutoDelete, opts.Exclusive, args)
+}
@@ -0,0 +1,44 @@
+package bunnyhop
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishDeduplicated_SetsHeader(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	callerHeaders := amqp.Table{"trace": "abc"}
+	err := client.PublishDeduplicated("orders", "created", "order-42", amqp.Publishing{Headers: callerHeaders})
+	require.NoError(t, err)
+
+	published := dialer.last().channel(0).published
+	require.Len(t, published, 1)
+	assert.Equal(t, amqp.Table{"trace": "abc", "x-deduplication-header": "order-42"}, published[0].Msg.Headers)
+	assert.Equal(t, amqp.Table{"trace": "abc"}, callerHeaders)
+
+	assert.Error(t, client.PublishDeduplicated("orders", "created", "", amqp.Publishing{}))
+}
+
+func TestDeclareDedupQueue_SetsArgument(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	_, err := client.DeclareDedupQueue("orders", QueueOptions{Args: amqp.Table{"x-max-length": int32(100)}})
+	require.NoError(t, err)
+
+	declared := dialer.last().channel(0).declaredQueues()
+	require.Len(t, declared, 1)
+	assert.Equal(t, fakeQueueDeclare{
+		Name:    "orders",
+		Durable: true,
+		Args:    amqp.Table{"x-max-length": int32(100), "x-message-deduplication": true},
+	}, declared[0])
+}
@@ -0,0 +1,124 @@
+package bunnyhop
+
+import (
+	"errors"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultPermanentFailureLimit số lần thử với lỗi vĩnh viễn khi không cấu hình MaxConsecutiveFailures
+const defaultPermanentFailureLimit = 3
+
+// ShouldReconnect phân loại mặc định cho lỗi connect: trả về false với lỗi
+// không thể tự hết khi thử lại (sai credentials, không có quyền vào vhost)
+func ShouldReconnect(err error) bool {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		switch amqpErr.Code {
+		case amqp.AccessRefused, amqp.NotAllowed:
+			return false
+		}
+	}
+	return true
+}
+
+// PublishError lỗi publish kèm phân loại có nên thử lại hay không
+type PublishError struct {
+	Err       error // Lỗi gốc
+	temporary bool
+}
+
+// Error trả về thông báo của lỗi gốc
+func (e *PublishError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap trả về lỗi gốc
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary trả về true nếu publish lại (có thể trên connection khác) có khả năng thành công
+func (e *PublishError) Temporary() bool {
+	return e.temporary
+}
+
+// newPublishError bọc lỗi publish và phân loại theo mã lỗi AMQP. Lỗi đã được
+// phân loại được giữ nguyên
+func newPublishError(err error) error {
+	var publishErr *PublishError
+	if err == nil || errors.As(err, &publishErr) {
+		return err
+	}
+	return &PublishError{Err: err, temporary: isTemporaryPublishError(err)}
+}
+
+// isTemporaryPublishError phân loại lỗi publish: lỗi do connection/channel bị
+// đóng hoặc broker quá tải là tạm thời, lỗi do chính message hoặc topology
+// (exchange không tồn tại, sai tham số, không có quyền) là vĩnh viễn
+func isTemporaryPublishError(err error) bool {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		switch amqpErr.Code {
+		case amqp.ContentTooLarge, amqp.NoRoute, amqp.AccessRefused, amqp.NotFound,
+			amqp.PreconditionFailed, amqp.NotAllowed, amqp.NotImplemented,
+			amqp.FrameError, amqp.SyntaxError, amqp.CommandInvalid, amqp.UnexpectedFrame:
+			return false
+		}
+	}
+	return true
+}
+
+// PingError lỗi của Client.Ping, phân biệt client chưa có connection với
+// broker không phản hồi (hoặc trả lỗi) trên connection đang mở
+type PingError struct {
+	Err          error // Lỗi gốc
+	notConnected bool
+}
+
+// Error trả về thông báo của lỗi gốc
+func (e *PingError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap trả về lỗi gốc
+func (e *PingError) Unwrap() error {
+	return e.Err
+}
+
+// NotConnected trả về true nếu client không có connection/channel dùng được,
+// false nếu connection mở nhưng broker không trả lời kịp hoặc trả lỗi
+func (e *PingError) NotConnected() bool {
+	return e.notConnected
+}
+
+// closeCodeTally đếm số lần connection/channel bị đóng theo mã lỗi AMQP
+type closeCodeTally struct {
+	mutex  sync.Mutex
+	counts map[int]int64
+}
+
+// add ghi nhận một lần đóng với mã lỗi code
+func (t *closeCodeTally) add(code int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[int]int64)
+	}
+	t.counts[code]++
+}
+
+// snapshot trả về bản sao số đếm, nil nếu chưa có lần đóng nào
+func (t *closeCodeTally) snapshot() map[int]int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.counts) == 0 {
+		return nil
+	}
+	counts := make(map[int]int64, len(t.counts))
+	for code, n := range t.counts {
+		counts[code] = n
+	}
+	return counts
+}
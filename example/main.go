@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -31,11 +32,10 @@ func main() {
 		log.Fatalf("Failed to start pool: %v", err)
 	}
 
-	// Đợi một chút để các connections được thiết lập
-	time.Sleep(2 * time.Second)
-
-	// Lấy client từ pool
-	client, err := pool.GetClient()
+	// Chờ đến khi có node healthy rồi lấy client từ pool
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	client, err := pool.GetClientContext(ctx)
+	cancel()
 	if err != nil {
 		log.Printf("Failed to get client: %v", err)
 	} else {
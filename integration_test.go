@@ -131,7 +131,7 @@ func BenchmarkClient_Connect(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		client := NewClient(config)
 		ctx := context.Background()
-		client.Connect(ctx)
+		client.ConnectOnce(ctx)
 		client.Close()
 	}
 }
@@ -201,19 +201,3 @@ func TestLoadBalancingStrategies(t *testing.T) {
 		})
 	}
 }
-
-// Add String method to LoadBalanceStrategy for testing
-func (lb LoadBalanceStrategy) String() string {
-	switch lb {
-	case RoundRobin:
-		return "RoundRobin"
-	case Random:
-		return "Random"
-	case LeastUsed:
-		return "LeastUsed"
-	case WeightedRoundRobin:
-		return "WeightedRoundRobin"
-	default:
-		return "Unknown"
-	}
-}
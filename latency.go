@@ -0,0 +1,95 @@
+package bunnyhop
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OperationConfirm tên operation khi ghi latency của publish confirm
+const OperationConfirm = "confirm"
+
+// OperationCheckout tên operation khi ghi thời gian từ AcquireClient đến lúc release
+const OperationCheckout = "checkout"
+
+// LatencyRecorder nhận các phép đo latency, ví dụ để đẩy sang hệ thống metrics
+type LatencyRecorder interface {
+	RecordLatency(operation string, latency time.Duration)
+}
+
+// ewmaAlpha trọng số của phép đo mới trong EWMA
+const ewmaAlpha = 0.2
+
+// ewma trung bình trượt có trọng số mũ của latency
+type ewma struct {
+	mutex sync.Mutex
+	value float64
+	set   bool
+}
+
+// observe cập nhật EWMA với một phép đo mới
+func (e *ewma) observe(d time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !e.set {
+		e.value = float64(d)
+		e.set = true
+		return
+	}
+	e.value = ewmaAlpha*float64(d) + (1-ewmaAlpha)*e.value
+}
+
+// get trả về giá trị EWMA hiện tại, 0 nếu chưa có phép đo
+func (e *ewma) get() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return time.Duration(e.value)
+}
+
+// latencyWindowSize số phép đo gần nhất được giữ để tính trung bình và p99
+const latencyWindowSize = 1024
+
+// latencyWindow giữ latencyWindowSize phép đo gần nhất trong ring buffer cố
+// định, bộ nhớ không tăng theo số operation
+type latencyWindow struct {
+	mutex   sync.Mutex
+	samples [latencyWindowSize]time.Duration
+	next    int
+	count   int
+}
+
+// observe ghi một phép đo, ghi đè phép đo cũ nhất khi buffer đầy
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+	if w.count < latencyWindowSize {
+		w.count++
+	}
+}
+
+// summary trả về trung bình và p99 (nearest-rank) của các phép đo trong
+// window, 0 nếu chưa có phép đo
+func (w *latencyWindow) summary() (avg, p99 time.Duration) {
+	w.mutex.Lock()
+	samples := make([]time.Duration, w.count)
+	copy(samples, w.samples[:w.count])
+	w.mutex.Unlock()
+
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	rank := (len(samples)*99 + 99) / 100
+	return total / time.Duration(len(samples)), samples[rank-1]
+}
+
+// durationMillis đổi d sang mili giây dạng số thực
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
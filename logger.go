@@ -1,7 +1,13 @@
 package bunnyhop
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
 )
 
 // DefaultLogger implementation mặc định
@@ -30,3 +36,104 @@ func (l *DefaultLogger) Debug(msg string, args ...interface{}) {
 		log.Printf("[DEBUG] "+msg, args...)
 	}
 }
+
+// SlogLogger chuyển log của bunnyhop sang *slog.Logger
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger tạo Logger ghi qua slog. Các verb printf trong msg (như log nội
+// bộ của bunnyhop) được format với các arg đầu tiên, arg còn lại được truyền
+// thành cặp key-value attribute
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Debug(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, msg, args)
+}
+
+func (l *SlogLogger) Info(msg string, args ...interface{}) {
+	l.log(slog.LevelInfo, msg, args)
+}
+
+func (l *SlogLogger) Warn(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, msg, args)
+}
+
+func (l *SlogLogger) Error(msg string, args ...interface{}) {
+	l.log(slog.LevelError, msg, args)
+}
+
+func (l *SlogLogger) log(level slog.Level, msg string, args []interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	n := countVerbs(msg)
+	if n > len(args) {
+		n = len(args)
+	}
+	if strings.Contains(msg, "%") {
+		msg = fmt.Sprintf(msg, args[:n]...)
+	}
+	l.logger.Log(ctx, level, msg, args[n:]...)
+}
+
+// countVerbs đếm số verb printf trong format, bỏ qua %%
+func countVerbs(format string) int {
+	count := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// defaultReconnectLogInterval khoảng tối thiểu giữa các lần log lặp lại cùng một lỗi connect
+const defaultReconnectLogInterval = time.Minute
+
+// logThrottle chặn log lặp lại của cùng một lỗi, chỉ log lại khi lỗi thay đổi
+// hoặc đã qua interval kể từ lần log trước
+type logThrottle struct {
+	mutex      sync.Mutex
+	lastMsg    string
+	lastLogged time.Time
+	suppressed int
+}
+
+// check trả về true nếu msg nên được log, kèm số lần cùng lỗi đã bị chặn kể từ lần log trước
+func (t *logThrottle) check(msg string, interval time.Duration) (bool, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if msg != t.lastMsg {
+		t.lastMsg = msg
+		t.lastLogged = now
+		t.suppressed = 0
+		return true, 0
+	}
+	if now.Sub(t.lastLogged) >= interval {
+		suppressed := t.suppressed
+		t.lastLogged = now
+		t.suppressed = 0
+		return true, suppressed
+	}
+	t.suppressed++
+	return false, 0
+}
+
+// reset xóa trạng thái khi kết nối thành công
+func (t *logThrottle) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastMsg = ""
+	t.suppressed = 0
+}
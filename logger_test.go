@@ -0,0 +1,41 @@
+package bunnyhop
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger_MapsLevelsAndAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	var logger Logger = NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Debug("hidden %s", "debug")
+	logger.Info("pool started")
+	logger.Warn("Node %s connection lost", "amqp://a:5672/", "attempt", 3)
+	logger.Error("100%% of nodes down", "healthy", 0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+
+	records := make([]map[string]interface{}, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &records[i]))
+	}
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "pool started", records[0]["msg"])
+
+	// Verb printf được format, arg còn lại thành attribute
+	assert.Equal(t, "WARN", records[1]["level"])
+	assert.Equal(t, "Node amqp://a:5672/ connection lost", records[1]["msg"])
+	assert.Equal(t, float64(3), records[1]["attempt"])
+
+	assert.Equal(t, "ERROR", records[2]["level"])
+	assert.Equal(t, "100% of nodes down", records[2]["msg"])
+	assert.Equal(t, float64(0), records[2]["healthy"])
+}
@@ -2,62 +2,182 @@ package bunnyhop
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// recoveryEventBuffer số recovery event được giữ lại cho consumer đọc chậm
+const recoveryEventBuffer = 16
+
+// healthEventBuffer số health event được giữ lại cho consumer đọc chậm
+const healthEventBuffer = 64
+
+// warmUpScale hệ số nhân weight của weighted round-robin khi bật warm-up
+const warmUpScale = 100
+
+// reliableRetryInterval thời gian chờ của PublishReliable khi không có node healthy
+const reliableRetryInterval = 100 * time.Millisecond
+
 // Pool quản lý pool các kết nối đến cluster RabbitMQ
 type Pool struct {
-	config       PoolConfig
-	nodes        []*NodeConnection
-	mutex        sync.RWMutex
-	closed       bool
-	roundRobin   int64
-	logger       Logger
-	ctx          context.Context
-	cancel       context.CancelFunc
-	healthTicker *time.Ticker
+	config          PoolConfig
+	nodes           []*NodeConnection
+	mutex           sync.RWMutex
+	closed          bool
+	roundRobin      int64
+	wrrMutex        sync.Mutex // Bảo vệ currentWeight của các node
+	logger          Logger
+	ctx             context.Context
+	cancel          context.CancelFunc
+	healthTicker    *time.Ticker
+	healthSem       chan struct{} // Giới hạn số health check đồng thời, nil là không giới hạn
+	recoveries      chan string   // ID của node vừa phục hồi
+	healthEvents    chan NodeHealthEvent
+	stopParentWatch func() bool   // Hủy việc đóng pool theo context cha
+	inFlightSem     chan struct{} // Giới hạn số client checkout đồng thời, nil là không giới hạn
+	healthy         broadcast     // Được notify mỗi khi một node có thể được chọn trở lại
+	idle            broadcast     // Được notify khi số in-flight giảm về 0
+	attempts        broadcast     // Được notify khi một node kết thúc lần connect đầu tiên
+	draining        bool          // Shutdown đang chờ in-flight, không nhận operation mới
+	ringMutex       sync.Mutex
+	ring            *hashRing     // Hash ring của ConsistentHash, dựng lại khi tập node healthy thay đổi
+	dialSem         chan struct{} // Giới hạn số lần dial đồng thời, nil là không giới hạn
 
 	// Metrics
 	totalRequests int64
 	totalFailures int64
+	inFlight      int64
 }
 
-// NewPool tạo pool mới
+// NewPool tạo pool mới. URL rỗng, sai định dạng hoặc bị trùng không tạo node,
+// các lỗi khác của config.Validate chỉ được log và giá trị được dùng như trước
 func NewPool(config PoolConfig) *Pool {
+	return NewPoolWithContext(context.Background(), config)
+}
+
+// NewPoolWithError tạo pool mới, trả về lỗi nếu config không hợp lệ (xem
+// PoolConfig.Validate) thay vì dùng giá trị mặc định
+func NewPoolWithError(config PoolConfig) (*Pool, error) {
+	if len(config.URLs) == 0 {
+		config.URLs = []string{defaultPoolURL}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pool config: %w", err)
+	}
+	return newPool(context.Background(), config), nil
+}
+
+// NewPoolWithContext tạo pool mới gắn với ctx: khi ctx bị hủy, pool được đóng
+// như khi gọi Close. Config được xử lý như NewPool; khi mọi URL đều không hợp
+// lệ, pool không có node nào thay vì dùng URL mặc định
+func NewPoolWithContext(parent context.Context, config PoolConfig) *Pool {
+	if len(config.URLs) == 0 {
+		config.URLs = []string{defaultPoolURL}
+	}
+	urls, urlErrs := usableURLs(config.URLs)
+	config.URLs = urls
+	configErr := config.Validate()
+
+	pool := newPool(parent, config)
+	for _, err := range urlErrs {
+		pool.logger.Warn("Ignoring node URL: %v", err)
+	}
+	if len(urls) == 0 {
+		pool.logger.Error("No valid node URL configured, pool has no nodes")
+	}
+	if configErr != nil {
+		pool.logger.Warn("Invalid pool config: %v", configErr)
+	}
+	return pool
+}
+
+// newPool tạo pool từ config đã được kiểm tra
+func newPool(parent context.Context, config PoolConfig) *Pool {
 	getDefaultConfig(&config)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(parent)
 
 	pool := &Pool{
-		config: config,
-		nodes:  make([]*NodeConnection, 0, len(config.URLs)),
-		logger: config.Logger,
-		ctx:    ctx,
-		cancel: cancel,
+		config:       config,
+		nodes:        make([]*NodeConnection, 0, len(config.URLs)),
+		logger:       config.Logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		recoveries:   make(chan string, recoveryEventBuffer),
+		healthEvents: make(chan NodeHealthEvent, healthEventBuffer),
+	}
+
+	if config.HealthCheckConcurrency > 0 {
+		pool.healthSem = make(chan struct{}, config.HealthCheckConcurrency)
+	}
+	if config.MaxTotalInFlight > 0 {
+		pool.inFlightSem = make(chan struct{}, config.MaxTotalInFlight)
+	}
+	if config.ReconnectConcurrency > 0 {
+		pool.dialSem = make(chan struct{}, config.ReconnectConcurrency)
 	}
 
 	// Khởi tạo nodes
 	for i, url := range config.URLs {
-		node := &NodeConnection{
-			URL:      url,
-			Client:   nil,
-			healthy:  false,
-			weight:   1, // Default weight
-			lastUsed: time.Now(),
-		}
-		pool.nodes = append(pool.nodes, node)
-		pool.logger.Debug("Initialized node %d: %s", i, url)
+		pool.nodes = append(pool.nodes, pool.newNode(url))
+		pool.logger.Debug("Initialized node %d: %s", i, pool.redact(url))
 	}
 
+	pool.stopParentWatch = context.AfterFunc(parent, func() {
+		if err := pool.Close(); err != nil {
+			pool.logger.Warn("Error closing pool after context cancellation: %v", err)
+		}
+	})
+
 	return pool
 }
 
-// Start bắt đầu pool
+// newNode tạo NodeConnection cho url theo NodeIDs, NodeTiers và tham số của url
+func (p *Pool) newNode(url string) *NodeConnection {
+	node := &NodeConnection{
+		ID:       p.config.NodeIDs[url],
+		tier:     p.config.NodeTiers[url],
+		URL:      url,
+		Client:   nil,
+		healthy:  false,
+		weight:   1, // Default weight
+		lastUsed: time.Now(),
+	}
+	if node.ID == "" {
+		node.ID = defaultNodeID(url)
+	}
+	if opts, err := parseURLOptions(url); err != nil {
+		p.logger.Warn("Ignoring options of node %s: %v", p.redact(url), err)
+	} else if opts.Weight > 0 {
+		node.weight = opts.Weight
+	}
+	return node
+}
+
+// Start bắt đầu pool. Khi bật RequireInitialConnection, Start chờ đến khi có
+// node connect thành công và trả lỗi nếu lần connect đầu tiên của mọi node
+// thất bại hoặc hết InitialConnectTimeout. Pool vẫn chạy khi Start trả lỗi,
+// caller cần gọi Close.
 func (p *Pool) Start() error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	if !p.config.RequireInitialConnection {
+		return nil
+	}
+	return p.waitInitialConnection()
+}
+
+// start tạo connection cho mỗi node và chạy health check
+func (p *Pool) start() error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
@@ -67,7 +187,7 @@ func (p *Pool) Start() error {
 
 	// Tạo connection cho mỗi node
 	for _, node := range p.nodes {
-		go p.connectToNode(node)
+		p.startConnect(node)
 	}
 
 	// Bắt đầu health check goroutine
@@ -78,53 +198,316 @@ func (p *Pool) Start() error {
 	return nil
 }
 
-// connectToNode tạo connection đến một node
+// waitInitialConnection chờ đến khi có node healthy, trả lỗi kèm lỗi connect
+// đầu tiên của các node khi mọi node đều connect thất bại hoặc hết timeout
+func (p *Pool) waitInitialConnection() error {
+	timer := time.NewTimer(p.config.InitialConnectTimeout)
+	defer timer.Stop()
+
+	for {
+		// Lấy channel trước khi kiểm tra để không bỏ lỡ thay đổi giữa hai bước
+		healthy, attempts := p.healthy.wait(), p.attempts.wait()
+		if p.GetHealthyNodeCount() > 0 {
+			return nil
+		}
+		if p.allNodesFailedFirstConnect() {
+			return fmt.Errorf("no node connected: %s", p.firstConnectSummary())
+		}
+
+		select {
+		case <-healthy:
+		case <-attempts:
+		case <-timer.C:
+			return fmt.Errorf("no node connected within %v: %s", p.config.InitialConnectTimeout, p.firstConnectSummary())
+		case <-p.ctx.Done():
+			return fmt.Errorf("pool is closed")
+		}
+	}
+}
+
+// allNodesFailedFirstConnect trả về true nếu mọi node đã kết thúc lần connect
+// đầu tiên và đều thất bại
+func (p *Pool) allNodesFailedFirstConnect() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, node := range p.nodes {
+		node.mutex.RLock()
+		failed := node.attempted && node.firstErr != nil
+		node.mutex.RUnlock()
+		if !failed {
+			return false
+		}
+	}
+	return true
+}
+
+// firstConnectSummary mô tả lỗi connect đầu tiên của các node, URL đã ẩn credentials
+func (p *Pool) firstConnectSummary() string {
+	errs := p.FirstConnectErrors()
+	if len(errs) == 0 {
+		return "no connect attempt finished"
+	}
+	urls := make([]string, 0, len(errs))
+	for url := range errs {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	parts := make([]string, 0, len(urls))
+	for _, url := range urls {
+		parts = append(parts, fmt.Sprintf("%s: %v", p.redact(url), errs[url]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// startConnect chạy connectToNode trong goroutine mới, đảm bảo mỗi node
+// chỉ có tối đa một lần connect đang chạy
+func (p *Pool) startConnect(node *NodeConnection) {
+	if !atomic.CompareAndSwapInt32(&node.connecting, 0, 1) {
+		return
+	}
+	go p.connectToNode(node)
+}
+
+// connectToNode tạo connection đến một node, chỉ được gọi qua startConnect
 func (p *Pool) connectToNode(node *NodeConnection) {
-	node.mutex.Lock()
-	defer node.mutex.Unlock()
+	defer atomic.StoreInt32(&node.connecting, 0)
 
-	if node.connecting {
+	node.mutex.Lock()
+	skip := node.removed || node.failed || !p.allowConnect(node, time.Now())
+	node.mutex.Unlock()
+	if skip {
 		return
 	}
-	node.connecting = true
-	defer func() { node.connecting = false }()
 
-	p.logger.Debug("Connecting to node %s", node.URL)
+	p.logger.Debug("Connecting to node %s", p.redact(node.URL))
 
 	client := NewClient(Config{
-		URLs:                []string{node.URL},
-		ReconnectInterval:   p.config.ReconnectInterval,
-		MaxReconnectAttempt: p.config.MaxReconnectAttempt,
-		DebugLog:            p.config.DebugLog,
-		Logger:              p.logger,
+		URLs:                   []string{node.URL},
+		ReconnectInterval:      p.config.ReconnectInterval,
+		MinReconnectInterval:   p.config.MinReconnectInterval,
+		MaxReconnectAttempt:    p.config.MaxReconnectAttempt,
+		DebugLog:               p.config.DebugLog,
+		Logger:                 p.logger,
+		QoSProfiles:            p.config.QoSProfiles,
+		DefaultHeaders:         p.config.DefaultHeaders,
+		FailedPublishSink:      p.config.FailedPublishSink,
+		OnChannelClose:         p.config.OnChannelClose,
+		GenerateMessageID:      p.config.GenerateMessageID,
+		QoSFailurePolicy:       p.config.QoSFailurePolicy,
+		DrainTimeout:           p.config.DrainTimeout,
+		AutoDeclareExchange:    p.config.AutoDeclareExchange,
+		ShowCredentials:        p.config.ShowCredentials,
+		ReconnectLogInterval:   p.config.ReconnectLogInterval,
+		AllowUnlimitedPrefetch: p.config.AllowUnlimitedPrefetch,
+		LatencyRecorder:        p.config.LatencyRecorder,
+		DryRun:                 p.config.DryRun,
+		Purpose:                p.config.Purpose,
+		Keepalive:              p.config.Keepalive,
+		Codec:                  p.config.Codec,
+		TLSConfig:              p.config.TLSConfig,
+		Heartbeat:              p.config.Heartbeat,
+		DialTimeout:            p.config.DialTimeout,
+		PrefetchCount:          p.config.PrefetchCount,
+		PrefetchSize:           p.config.PrefetchSize,
+		GlobalQos:              p.config.GlobalQos,
+		Username:               p.config.Username,
+		Password:               p.config.Password,
+		Vhost:                  p.config.Vhost,
+		MaxChannels:            p.config.MaxChannels,
+		Backoff:                p.config.Backoff,
+		onCloseError:           func(err *amqp.Error) { node.closeCodes.add(err.Code) },
+		dial:                   p.nodeDial(node),
 	})
 
+	// Dial không giữ node.mutex để GetClient không bị chặn khi node đang restart
 	err := client.Connect(p.ctx)
+
+	// Close chờ consumer drain tối đa DrainTimeout nên client bị thay được đóng
+	// sau khi nhả node.mutex
+	var stale *Client
+	defer func() {
+		if stale != nil {
+			stale.Close()
+		}
+	}()
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	// Node bị xóa hoặc pool đóng trong lúc dial
+	if node.removed || p.ctx.Err() != nil {
+		if err == nil {
+			stale = client
+		}
+		return
+	}
+
+	if !node.attempted {
+		node.attempted = true
+		node.firstErr = err
+		defer p.attempts.notify()
+	}
+
 	if err != nil {
-		p.logger.Error("Failed to connect to node %s: %v", node.URL, err)
+		if ok, suppressed := node.connectLog.check(err.Error(), p.config.ReconnectLogInterval); ok {
+			if suppressed > 0 {
+				p.logger.Error("Node %s still failing after %d more attempts: %v", p.redact(node.URL), suppressed, err)
+			} else {
+				p.logger.Error("Failed to connect to node %s: %v", p.redact(node.URL), err)
+			}
+		}
 		atomic.AddInt64(&node.failures, 1)
-		node.healthy = false
+		p.recordFailure(node)
+		p.setNodeHealthy(node, false)
+		node.consecutiveFailures++
+
+		if limit := p.failureLimit(err); limit > 0 && node.consecutiveFailures >= limit {
+			node.failed = true
+			p.logger.Error("Node %s marked as failed after %d consecutive failures, giving up: %v",
+				p.redact(node.URL), node.consecutiveFailures, err)
+			if p.config.OnNodeFailed != nil {
+				go p.config.OnNodeFailed(node.URL, err)
+			}
+			return
+		}
 
-		// Thử reconnect sau một khoảng thời gian
-		time.AfterFunc(p.config.ReconnectInterval, func() {
-			p.connectToNode(node)
+		// Thử reconnect sau một khoảng thời gian, hoặc sau cooldown nếu breaker mở
+		delay := reconnectDelay(p.config.ReconnectInterval, p.config.MinReconnectInterval)
+		if p.tripCircuit(node, err) {
+			delay = node.circuitCooldown
+		}
+		time.AfterFunc(delay, func() {
+			p.startConnect(node)
 		})
 		return
 	}
 
-	// Nếu đã có client cũ, đóng nó
-	if node.Client != nil {
-		node.Client.Close()
-	}
+	// Nếu đã có client cũ, đóng nó sau khi nhả node.mutex
+	recovered := node.Client != nil
+	stale = node.Client
 
 	node.Client = client
-	node.healthy = true
-	p.logger.Info("Successfully connected to node %s", node.URL)
+	p.setNodeHealthy(node, true)
+	p.healthy.notify()
+	node.consecutiveFailures = 0
+	p.closeCircuit(node)
+	node.connectLog.reset()
+	p.logger.Info("Successfully connected to node %s", p.redact(node.URL))
+	if recovered {
+		p.emitRecovery(node)
+	}
 
 	// Theo dõi trạng thái connection
 	go p.watchNodeConnection(node)
 }
 
+// nodeDial trả về hàm dial cho client của node. Khi cả cluster phục hồi, mọi
+// node cùng reconnect một lúc nên các lần dial được giới hạn bởi
+// ReconnectConcurrency và lần dial reconnect được trễ ngẫu nhiên theo ReconnectJitter.
+// Không được lấy node.mutex vì dial chạy khi đang giữ mutex của client.
+func (p *Pool) nodeDial(node *NodeConnection) dialFunc {
+	dial := p.config.dial
+	if dial == nil {
+		dial = defaultDial
+	}
+
+	return func(url string, config amqp.Config) (amqpConnection, error) {
+		first := atomic.CompareAndSwapInt32(&node.dialed, 0, 1)
+		if !first && p.config.ReconnectJitter > 0 {
+			timer := time.NewTimer(time.Duration(rand.Int63n(int64(p.config.ReconnectJitter))))
+			select {
+			case <-timer.C:
+			case <-p.ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("pool is closed")
+			}
+		}
+
+		if p.dialSem != nil {
+			select {
+			case p.dialSem <- struct{}{}:
+				defer func() { <-p.dialSem }()
+			case <-p.ctx.Done():
+				return nil, fmt.Errorf("pool is closed")
+			}
+		}
+		return dial(url, config)
+	}
+}
+
+// allowConnect cho biết circuit breaker có cho phép connect đến node không. Breaker
+// mở hết cooldown chuyển sang half-open, lần connect này là probe duy nhất vì
+// startConnect chỉ cho một lần connect chạy mỗi node. Phải giữ node.mutex
+func (p *Pool) allowConnect(node *NodeConnection, now time.Time) bool {
+	if node.circuit != CircuitOpen {
+		return true
+	}
+	if now.Before(node.circuitUntil) {
+		return false
+	}
+	node.circuit = CircuitHalfOpen
+	p.logger.Info("Circuit breaker of node %s is half-open, probing", p.redact(node.URL))
+	return true
+}
+
+// tripCircuit mở breaker khi probe half-open lỗi (cooldown tăng gấp đôi) hoặc khi
+// số lần lỗi liên tiếp đạt FailureThreshold, trả về true nếu breaker đang mở.
+// Phải giữ node.mutex
+func (p *Pool) tripCircuit(node *NodeConnection, err error) bool {
+	cfg := p.config.CircuitBreaker
+	if cfg.FailureThreshold <= 0 {
+		return false
+	}
+
+	switch {
+	case node.circuit == CircuitHalfOpen:
+		node.circuitCooldown *= 2
+		if node.circuitCooldown > cfg.MaxCooldown {
+			node.circuitCooldown = cfg.MaxCooldown
+		}
+	case node.consecutiveFailures >= cfg.FailureThreshold:
+		node.circuitCooldown = cfg.Cooldown
+	default:
+		return false
+	}
+
+	node.circuit = CircuitOpen
+	node.circuitUntil = time.Now().Add(node.circuitCooldown)
+	p.logger.Warn("Circuit breaker of node %s opened for %v: %v", p.redact(node.URL), node.circuitCooldown, err)
+	return true
+}
+
+// closeCircuit đóng breaker sau khi connect thành công, phải giữ node.mutex
+func (p *Pool) closeCircuit(node *NodeConnection) {
+	if node.circuit != CircuitClosed {
+		p.logger.Info("Circuit breaker of node %s closed", p.redact(node.URL))
+	}
+	node.circuit = CircuitClosed
+	node.circuitCooldown = 0
+	node.circuitUntil = time.Time{}
+}
+
+// redact ẩn credentials trong URL trước khi log, trừ khi ShowCredentials được bật
+func (p *Pool) redact(url string) string {
+	if p.config.ShowCredentials {
+		return url
+	}
+	return redactURL(url)
+}
+
+// failureLimit số lần lỗi liên tiếp tối đa trước khi node bị đánh dấu failed, 0 là
+// không giới hạn. Lỗi vĩnh viễn (sai credentials, vhost...) luôn có giới hạn.
+func (p *Pool) failureLimit(err error) int {
+	if p.config.ShouldReconnect(err) {
+		return p.config.MaxConsecutiveFailures
+	}
+	if p.config.MaxConsecutiveFailures > 0 {
+		return p.config.MaxConsecutiveFailures
+	}
+	return defaultPermanentFailureLimit
+}
+
 // watchNodeConnection theo dõi trạng thái connection của node
 func (p *Pool) watchNodeConnection(node *NodeConnection) {
 	ticker := time.NewTicker(10 * time.Second)
@@ -136,15 +519,19 @@ func (p *Pool) watchNodeConnection(node *NodeConnection) {
 			return
 		case <-ticker.C:
 			node.mutex.RLock()
+			if node.removed {
+				node.mutex.RUnlock()
+				return
+			}
 			if node.Client != nil && !node.Client.IsConnected() {
 				node.mutex.RUnlock()
 				node.mutex.Lock()
-				node.healthy = false
+				p.setNodeHealthy(node, false)
 				node.mutex.Unlock()
-				p.logger.Warn("Node %s connection lost", node.URL)
+				p.logger.Warn("Node %s connection lost", p.redact(node.URL))
 
 				// Tự động reconnect
-				go p.connectToNode(node)
+				p.startConnect(node)
 				return
 			}
 			node.mutex.RUnlock()
@@ -152,21 +539,175 @@ func (p *Pool) watchNodeConnection(node *NodeConnection) {
 	}
 }
 
-// GetClient lấy một client từ pool theo load balancing strategy
+// GetClient lấy một client từ pool theo load balancing strategy. Client không
+// được tính là in-flight, nhưng vẫn chờ hoặc trả lỗi khi đã đạt MaxTotalInFlight
 func (p *Pool) GetClient() (*Client, error) {
+	return p.GetClientWithStrategy(p.config.LoadBalanceStrategy)
+}
+
+// GetClientWithStrategy lấy một client theo strategy chỉ định cho lần gọi này,
+// không thay đổi strategy mặc định của pool
+func (p *Pool) GetClientWithStrategy(strategy LoadBalanceStrategy) (*Client, error) {
+	_, client, release, err := p.checkout(p.ctx, strategy, "")
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return client, nil
+}
+
+// GetClientForKey lấy client của node mà key được ánh xạ tới trên hash ring
+// của các node healthy. Cùng key luôn về cùng node cho đến khi tập node healthy
+// thay đổi, khi đó chỉ các key của node bị thêm/bớt được phân bổ lại
+func (p *Pool) GetClientForKey(key string) (*Client, error) {
+	_, client, release, err := p.checkout(p.ctx, ConsistentHash, key)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return client, nil
+}
+
+// GetClientContext lấy một client như GetClient, nhưng chờ đến khi có node
+// healthy hoặc ctx hết hạn thay vì trả lỗi ngay, ví dụ ngay sau Start
+func (p *Pool) GetClientContext(ctx context.Context) (*Client, error) {
+	release, err := p.acquireInFlight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	for {
+		// Lấy channel trước khi chọn node để không bỏ lỡ node healthy giữa hai bước
+		changed := p.healthy.wait()
+		_, client, err := p.selectNode(p.config.LoadBalanceStrategy)
+		if err == nil {
+			return client, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no client available before context expired: %w (last error: %v)", ctx.Err(), err)
+		case <-p.ctx.Done():
+			return nil, fmt.Errorf("pool is closed")
+		}
+	}
+}
+
+// AcquireClient checkout một client theo load balancing strategy. Client được
+// tính là in-flight cho đến khi gọi hàm release trả về, thời gian giữ client
+// được ghi vào NodeStats.CheckoutLatency, latency của node và LatencyRecorder
+func (p *Pool) AcquireClient() (*Client, func(), error) {
+	node, client, release, err := p.checkout(p.ctx, p.config.LoadBalanceStrategy, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	var once sync.Once
+	return client, func() {
+		once.Do(func() {
+			latency := time.Since(start)
+			node.checkoutLatency.observe(latency)
+			node.latency.observe(latency)
+			if p.config.LatencyRecorder != nil {
+				p.config.LatencyRecorder.RecordLatency(OperationCheckout, latency)
+			}
+			release()
+		})
+	}, nil
+}
+
+// checkout chiếm một slot in-flight rồi chọn node theo strategy
+func (p *Pool) checkout(ctx context.Context, strategy LoadBalanceStrategy, key string) (*NodeConnection, *Client, func(), error) {
+	release, err := p.acquireInFlight(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	node, client, err := p.selectNodeForKey(strategy, key)
+	if err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+
+	done := node.begin()
+	var once sync.Once
+	return node, client, func() {
+		once.Do(func() {
+			done()
+			release()
+		})
+	}, nil
+}
+
+// acquireInFlight tăng số in-flight của pool, chờ hoặc trả lỗi theo
+// InFlightPolicy khi đã đạt MaxTotalInFlight. Hàm release trả về chỉ có tác
+// dụng ở lần gọi đầu tiên
+func (p *Pool) acquireInFlight(ctx context.Context) (func(), error) {
+	if p.inFlightSem != nil {
+		select {
+		case p.inFlightSem <- struct{}{}:
+		default:
+			if p.config.InFlightPolicy == InFlightReject {
+				atomic.AddInt64(&p.totalFailures, 1)
+				return nil, fmt.Errorf("pool in-flight limit of %d reached", p.config.MaxTotalInFlight)
+			}
+			select {
+			case p.inFlightSem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p.ctx.Done():
+				return nil, fmt.Errorf("pool is closed")
+			}
+		}
+	}
+
+	// Kiểm tra và tăng in-flight cùng lúc để Shutdown không bỏ sót operation
+	p.mutex.RLock()
+	if p.draining {
+		p.mutex.RUnlock()
+		if p.inFlightSem != nil {
+			<-p.inFlightSem
+		}
+		return nil, fmt.Errorf("pool is shutting down")
+	}
+	atomic.AddInt64(&p.inFlight, 1)
+	p.mutex.RUnlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if atomic.AddInt64(&p.inFlight, -1) == 0 {
+				p.idle.notify()
+			}
+			if p.inFlightSem != nil {
+				<-p.inFlightSem
+			}
+		})
+	}, nil
+}
+
+// selectNode chọn node theo strategy và cập nhật thống kê sử dụng của node
+func (p *Pool) selectNode(strategy LoadBalanceStrategy) (*NodeConnection, *Client, error) {
+	return p.selectNodeForKey(strategy, "")
+}
+
+// selectNodeForKey như selectNode, key được dùng bởi ConsistentHash
+func (p *Pool) selectNodeForKey(strategy LoadBalanceStrategy, key string) (*NodeConnection, *Client, error) {
 	atomic.AddInt64(&p.totalRequests, 1)
 
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
 	if p.closed {
-		return nil, fmt.Errorf("pool is closed")
+		return nil, nil, fmt.Errorf("pool is closed")
 	}
 
 	var selectedNode *NodeConnection
 	var err error
 
-	switch p.config.LoadBalanceStrategy {
+	switch strategy {
 	case RoundRobin:
 		selectedNode, err = p.getClientRoundRobin()
 	case Random:
@@ -175,13 +716,23 @@ func (p *Pool) GetClient() (*Client, error) {
 		selectedNode, err = p.getClientLeastUsed()
 	case WeightedRoundRobin:
 		selectedNode, err = p.getClientWeightedRoundRobin()
+	case LeastFailures:
+		selectedNode, err = p.getClientLeastFailures()
+	case LeastConnections:
+		selectedNode, err = p.getClientLeastConnections()
+	case ConsistentHash:
+		if key == "" {
+			selectedNode, err = p.getClientRoundRobin()
+		} else {
+			selectedNode, err = p.getClientConsistentHash(key)
+		}
 	default:
 		selectedNode, err = p.getClientRoundRobin()
 	}
 
 	if err != nil {
 		atomic.AddInt64(&p.totalFailures, 1)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Update usage stats
@@ -191,7 +742,208 @@ func (p *Pool) GetClient() (*Client, error) {
 	client := selectedNode.Client
 	selectedNode.mutex.Unlock()
 
-	return client, nil
+	return selectedNode, client, nil
+}
+
+// Publish gửi message qua một client chọn theo load balancing strategy. Nếu
+// publish lỗi tạm thời, message được gửi lại trên node healthy chưa thử, tối
+// đa PublishMaxAttempts node
+func (p *Pool) Publish(
+	exchange, routingKey string,
+	mandatory, immediate bool,
+	msg amqp.Publishing,
+) error {
+	release, err := p.acquireInFlight(p.ctx)
+	if err != nil {
+		return &PublishError{Err: err, temporary: p.ctx.Err() == nil}
+	}
+	defer release()
+
+	tried := make(map[*NodeConnection]bool)
+	var lastErr error
+	for attempt := 1; attempt <= p.config.PublishMaxAttempts; attempt++ {
+		node, client, err := p.selectReliableNode(tried)
+		if err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			// Không có node healthy là tạm thời, pool đã đóng thì không
+			return &PublishError{Err: err, temporary: p.ctx.Err() == nil}
+		}
+		if tried[node] {
+			// Mọi node healthy đã được thử
+			return lastErr
+		}
+		tried[node] = true
+
+		done := node.begin()
+		start := time.Now()
+		err = client.PublishMessage(exchange, routingKey, mandatory, immediate, msg)
+		node.latency.observe(time.Since(start))
+		done()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		atomic.AddInt64(&node.publishFailures, 1)
+		p.recordFailure(node)
+
+		var publishErr *PublishError
+		if errors.As(err, &publishErr) && !publishErr.Temporary() {
+			return err
+		}
+		if attempt < p.config.PublishMaxAttempts {
+			p.logger.Warn("Publish on node %s failed, retrying on another node: %v", p.redact(node.URL), err)
+		}
+	}
+	return lastErr
+}
+
+// PublishReliable publish message với confirm, nếu node lỗi trước khi broker ack
+// thì publish lại trên node healthy khác cho đến khi được ack hoặc ctx hết hạn.
+// Message có thể bị trùng khi node lỗi sau khi đã nhận message.
+func (p *Pool) PublishReliable(
+	ctx context.Context,
+	exchange, routingKey string,
+	mandatory bool,
+	msg amqp.Publishing,
+) error {
+	release, err := p.acquireInFlight(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tried := make(map[*NodeConnection]bool)
+	var lastErr error
+
+	for {
+		if err := ctx.Err(); err != nil {
+			if lastErr != nil {
+				return fmt.Errorf("publish not confirmed before context expired: %w (last error: %v)", err, lastErr)
+			}
+			return err
+		}
+
+		node, client, err := p.selectReliableNode(tried)
+		if err != nil {
+			lastErr = err
+			select {
+			case <-ctx.Done():
+			case <-time.After(reliableRetryInterval):
+			}
+			continue
+		}
+
+		tried[node] = true
+		atomic.AddInt64(&node.publishAttempts, 1)
+		done := node.begin()
+		start := time.Now()
+		err = client.PublishWithConfirm(ctx, exchange, routingKey, mandatory, msg)
+		node.latency.observe(time.Since(start))
+		done()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+
+		lastErr = err
+		p.recordFailure(node)
+		p.logger.Warn("Publish on node %s was not confirmed, retrying on another node: %v", p.redact(node.URL), err)
+	}
+}
+
+// selectReliableNode chọn node cho Publish và PublishReliable, ưu tiên node healthy chưa thử
+func (p *Pool) selectReliableNode(tried map[*NodeConnection]bool) (*NodeConnection, *Client, error) {
+	node, client, err := p.selectNode(p.config.LoadBalanceStrategy)
+	if err != nil || !tried[node] {
+		return node, client, err
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, candidate := range p.getHealthyNodes() {
+		if !tried[candidate] {
+			candidate.mutex.RLock()
+			defer candidate.mutex.RUnlock()
+			return candidate, candidate.Client, nil
+		}
+	}
+	// Mọi node healthy đã được thử, thử lại trên node vừa chọn
+	return node, client, nil
+}
+
+// Subscribe đăng ký consumer trên queue ở các node chọn theo opts.Placement.
+// Dùng cho queue không mirror, mỗi node có queue riêng cùng tên. Nếu một node
+// lỗi, các consumer đã đăng ký ở node khác bị hủy.
+func (p *Pool) Subscribe(queue string, opts SubscribeOptions, handler func(amqp.Delivery)) error {
+	p.mutex.RLock()
+	if p.closed {
+		p.mutex.RUnlock()
+		return fmt.Errorf("pool is closed")
+	}
+	if p.draining {
+		p.mutex.RUnlock()
+		return fmt.Errorf("pool is shutting down")
+	}
+	healthy := p.getHealthyNodes()
+	p.mutex.RUnlock()
+
+	if len(healthy) == 0 {
+		return fmt.Errorf("no healthy nodes available")
+	}
+
+	var clients []*Client
+	switch opts.Placement {
+	case PlacementSingle:
+		client, err := p.GetClient()
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+	case PlacementAll, PlacementPerNode:
+		count := len(healthy)
+		if opts.Placement == PlacementPerNode {
+			if opts.NodeCount <= 0 {
+				return fmt.Errorf("node count must be positive for per-node placement")
+			}
+			if opts.NodeCount < count {
+				count = opts.NodeCount
+			}
+		}
+		start := int(atomic.AddInt64(&p.roundRobin, 1))
+		for i := 0; i < count; i++ {
+			node := healthy[(start+i)%len(healthy)]
+			node.mutex.RLock()
+			clients = append(clients, node.Client)
+			node.mutex.RUnlock()
+		}
+	default:
+		return fmt.Errorf("unknown consumer placement: %d", opts.Placement)
+	}
+
+	var subscribed []string
+	for i, client := range clients {
+		consumeOpts := opts.ConsumeOptions
+		if consumeOpts.ConsumerTag != "" && len(clients) > 1 {
+			consumeOpts.ConsumerTag = fmt.Sprintf("%s-%d", opts.ConsumerTag, i)
+		}
+		if consumeOpts.ConsumerTag == "" {
+			consumeOpts.ConsumerTag = nextConsumerTag()
+		}
+		if err := client.Consume(queue, consumeOpts, handler); err != nil {
+			for j, tag := range subscribed {
+				clients[j].CancelConsumer(tag)
+			}
+			return err
+		}
+		subscribed = append(subscribed, consumeOpts.ConsumerTag)
+	}
+
+	p.logger.Info("Subscribed %d consumers to queue %s", len(subscribed), queue)
+	return nil
 }
 
 // getClientRoundRobin lựa chọn client theo round robin
@@ -202,6 +954,9 @@ func (p *Pool) getClientRoundRobin() (*NodeConnection, error) {
 	}
 
 	index := int(atomic.AddInt64(&p.roundRobin, 1)) % len(healthyNodes)
+	if p.config.DebugLog {
+		p.logger.Debug("Round-robin picked node %s (index %d of %d healthy nodes)", healthyNodes[index].ID, index, len(healthyNodes))
+	}
 	return healthyNodes[index], nil
 }
 
@@ -213,6 +968,9 @@ func (p *Pool) getClientRandom() (*NodeConnection, error) {
 	}
 
 	index := rand.Intn(len(healthyNodes))
+	if p.config.DebugLog {
+		p.logger.Debug("Random picked node %s (index %d of %d healthy nodes)", healthyNodes[index].ID, index, len(healthyNodes))
+	}
 	return healthyNodes[index], nil
 }
 
@@ -234,53 +992,165 @@ func (p *Pool) getClientLeastUsed() (*NodeConnection, error) {
 		}
 	}
 
+	if p.config.DebugLog {
+		p.logger.Debug("Least-used picked node %s with %d uses among %d healthy nodes", selectedNode.ID, minUsed, len(healthyNodes))
+	}
 	return selectedNode, nil
 }
 
-// getClientWeightedRoundRobin lựa chọn theo weighted round robin
-func (p *Pool) getClientWeightedRoundRobin() (*NodeConnection, error) {
+// getClientLeastConnections lựa chọn node có ít operation in-flight nhất,
+// nếu bằng nhau thì chọn node ít được sử dụng hơn
+func (p *Pool) getClientLeastConnections() (*NodeConnection, error) {
 	healthyNodes := p.getHealthyNodes()
 	if len(healthyNodes) == 0 {
 		return nil, fmt.Errorf("no healthy nodes available")
 	}
 
-	// Tính tổng weight
-	totalWeight := 0
+	var selectedNode *NodeConnection
+	var minInFlight, minUsed int64
 	for _, node := range healthyNodes {
-		totalWeight += node.weight
+		inFlight := atomic.LoadInt64(&node.inFlight)
+		used := atomic.LoadInt64(&node.totalUsed)
+		if selectedNode == nil || inFlight < minInFlight || (inFlight == minInFlight && used < minUsed) {
+			selectedNode, minInFlight, minUsed = node, inFlight, used
+		}
 	}
 
-	if totalWeight == 0 {
-		// Fallback to round robin
-		return p.getClientRoundRobin()
+	if p.config.DebugLog {
+		p.logger.Debug("Least-connections picked node %s with %d in-flight operations among %d healthy nodes",
+			selectedNode.ID, minInFlight, len(healthyNodes))
+	}
+	return selectedNode, nil
+}
+
+// getClientLeastFailures lựa chọn node có ít lỗi nhất trong FailureWindow,
+// nếu bằng nhau thì chọn node ít được sử dụng hơn
+func (p *Pool) getClientLeastFailures() (*NodeConnection, error) {
+	healthyNodes := p.getHealthyNodes()
+	if len(healthyNodes) == 0 {
+		return nil, fmt.Errorf("no healthy nodes available")
+	}
+
+	now := time.Now()
+	var selectedNode *NodeConnection
+	var minFailures, minUsed int64
+	for _, node := range healthyNodes {
+		failures := node.recentFailures.count(now, p.config.FailureWindow)
+		used := atomic.LoadInt64(&node.totalUsed)
+		if selectedNode == nil || failures < minFailures || (failures == minFailures && used < minUsed) {
+			selectedNode, minFailures, minUsed = node, failures, used
+		}
+	}
+
+	if p.config.DebugLog {
+		p.logger.Debug("Least-failures picked node %s with %d recent failures among %d healthy nodes",
+			selectedNode.ID, minFailures, len(healthyNodes))
+	}
+	return selectedNode, nil
+}
+
+// getClientConsistentHash lựa chọn node mà key được ánh xạ tới trên hash ring
+// của các node healthy, ring được dựng lại khi tập node healthy thay đổi
+func (p *Pool) getClientConsistentHash(key string) (*NodeConnection, error) {
+	healthyNodes := p.getHealthyNodes()
+	if len(healthyNodes) == 0 {
+		return nil, fmt.Errorf("no healthy nodes available")
+	}
+
+	p.ringMutex.Lock()
+	if p.ring == nil || !p.ring.matches(healthyNodes) {
+		p.ring = newHashRing(healthyNodes)
+		p.logger.Debug("Rebuilt consistent hash ring with %d nodes", len(healthyNodes))
+	}
+	selectedNode := p.ring.get(key)
+	p.ringMutex.Unlock()
+
+	if p.config.DebugLog {
+		p.logger.Debug("Consistent hash picked node %s for key %s among %d healthy nodes", selectedNode.ID, key, len(healthyNodes))
+	}
+	return selectedNode, nil
+}
+
+// begin tăng số operation in-flight của node, hàm trả về giảm lại
+func (node *NodeConnection) begin() func() {
+	atomic.AddInt64(&node.inFlight, 1)
+	return func() { atomic.AddInt64(&node.inFlight, -1) }
+}
+
+// recordFailure ghi nhận một lỗi gần đây của node
+func (p *Pool) recordFailure(node *NodeConnection) {
+	node.recentFailures.add(time.Now(), p.config.FailureWindow)
+}
+
+// getClientWeightedRoundRobin lựa chọn theo smooth weighted round robin (như nginx):
+// mỗi lượt cộng weight vào currentWeight của từng node, chọn node có currentWeight
+// lớn nhất rồi trừ đi tổng weight. Với weight {5,1,1} thứ tự là a a b a c a a.
+func (p *Pool) getClientWeightedRoundRobin() (*NodeConnection, error) {
+	healthyNodes := p.getHealthyNodes()
+	if len(healthyNodes) == 0 {
+		return nil, fmt.Errorf("no healthy nodes available")
 	}
 
-	// Random selection based on weight
-	randWeight := rand.Intn(totalWeight)
-	currentWeight := 0
+	p.wrrMutex.Lock()
+	defer p.wrrMutex.Unlock()
 
+	// Khi có warm-up, weight được nhân warmUpScale để tăng dần mịn hơn
+	scale := 1
+	if p.config.WarmUpDuration > 0 {
+		scale = warmUpScale
+	}
+	now := time.Now()
+
+	var selectedNode *NodeConnection
+	totalWeight := 0
 	for _, node := range healthyNodes {
-		currentWeight += node.weight
-		if randWeight < currentWeight {
-			return node, nil
+		node.mutex.RLock()
+		weight := node.effectiveWeight(now, p.config.WarmUpDuration, scale)
+		node.mutex.RUnlock()
+		if weight <= 0 {
+			continue
+		}
+
+		node.currentWeight += weight
+		totalWeight += weight
+		if selectedNode == nil || node.currentWeight > selectedNode.currentWeight {
+			selectedNode = node
 		}
 	}
 
-	// Fallback to first healthy node
-	return healthyNodes[0], nil
+	if selectedNode == nil {
+		// Fallback to round robin
+		return p.getClientRoundRobin()
+	}
+
+	if p.config.DebugLog {
+		p.logger.Debug("Weighted round-robin picked node %s (current weight %d, total weight %d)",
+			selectedNode.ID, selectedNode.currentWeight, totalWeight)
+	}
+	selectedNode.currentWeight -= totalWeight
+	return selectedNode, nil
 }
 
 // getHealthyNodes trả về danh sách nodes đang healthy
 func (p *Pool) getHealthyNodes() []*NodeConnection {
-	var healthyNodes []*NodeConnection
+	var primaries, backups []*NodeConnection
 	for _, node := range p.nodes {
 		node.mutex.RLock()
-		if node.healthy && node.Client != nil && node.Client.IsConnected() {
-			healthyNodes = append(healthyNodes, node)
+		if node.healthy && !node.isCordoned() && node.Client != nil && node.Client.IsChannelOpen() {
+			if node.tier == BackupTier {
+				backups = append(backups, node)
+			} else {
+				primaries = append(primaries, node)
+			}
 		}
 		node.mutex.RUnlock()
 	}
-	return healthyNodes
+
+	// Node backup chỉ được dùng khi không còn node primary nào healthy
+	if len(primaries) > 0 {
+		return primaries
+	}
+	return backups
 }
 
 // healthCheckWorker worker để thực hiện health check định kỳ
@@ -299,40 +1169,229 @@ func (p *Pool) healthCheckWorker() {
 func (p *Pool) performHealthCheck() {
 	p.logger.Debug("Performing health check on all nodes")
 
-	for _, node := range p.nodes {
-		go p.checkNodeHealth(node)
+	p.mutex.RLock()
+	nodes := p.nodes
+	p.mutex.RUnlock()
+
+	now := time.Now()
+	for _, node := range nodes {
+		node.mutex.RLock()
+		due := !now.Before(node.nextProbe)
+		node.mutex.RUnlock()
+
+		// Node đang lỗi được probe thưa dần theo probeInterval của nó
+		if due {
+			p.startHealthCheck(node)
+		}
+	}
+}
+
+// startHealthCheck chạy health check của node trong goroutine riêng. Bỏ qua
+// nếu lần trước vẫn đang chờ HealthCheckConcurrency hoặc đang chạy, để broker
+// chậm không làm số goroutine tăng qua mỗi tick
+func (p *Pool) startHealthCheck(node *NodeConnection) {
+	if !atomic.CompareAndSwapInt32(&node.probing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&node.probing, 0)
+		p.runHealthCheck(node)
+	}()
+}
+
+// runHealthCheck chạy checkNodeHealth trong giới hạn HealthCheckConcurrency,
+// chỉ được gọi qua startHealthCheck
+func (p *Pool) runHealthCheck(node *NodeConnection) {
+	if p.healthSem != nil {
+		select {
+		case p.healthSem <- struct{}{}:
+			defer func() { <-p.healthSem }()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+	p.updateCordon(node)
+	p.checkNodeHealth(node)
+}
+
+// updateCordon cập nhật trạng thái cordon của node theo CordonCheck
+func (p *Pool) updateCordon(node *NodeConnection) {
+	if p.config.CordonCheck == nil {
+		return
+	}
+	cordoned := p.config.CordonCheck(node.URL)
+
+	node.mutex.Lock()
+	changed := node.checkCordoned != cordoned
+	node.checkCordoned = cordoned
+	node.mutex.Unlock()
+
+	if changed {
+		p.logger.Info("Node %s cordon check changed to %v", p.redact(node.URL), cordoned)
+		if !cordoned {
+			p.healthy.notify()
+		}
 	}
 }
 
 // checkNodeHealth kiểm tra health của một node
 func (p *Pool) checkNodeHealth(node *NodeConnection) {
+	// Ping không giữ node.mutex để GetClient không bị chặn khi broker chậm
+	pinged, pingErr := p.pingNode(node)
+
 	node.mutex.Lock()
 	defer node.mutex.Unlock()
 
+	// Node đã bỏ cuộc thì không thử kết nối lại
+	if node.failed {
+		return
+	}
+
 	if node.Client == nil {
-		node.healthy = false
-		p.logger.Debug("Node %s has no client", node.URL)
+		p.setNodeHealthy(node, false)
+		p.backoffProbe(node)
+		p.logger.Debug("Node %s has no client", p.redact(node.URL))
 
 		// Thử tạo connection
-		go p.connectToNode(node)
+		p.startConnect(node)
 		return
 	}
 
 	// Kiểm tra connection
 	if !node.Client.IsConnected() {
-		node.healthy = false
-		p.logger.Debug("Node %s connection is not healthy", node.URL)
+		p.setNodeHealthy(node, false)
+		p.backoffProbe(node)
+		p.logger.Debug("Node %s connection is not healthy", p.redact(node.URL))
 
 		// Thử reconnect
-		go p.connectToNode(node)
+		p.startConnect(node)
+		return
+	}
+
+	// Connection còn nhưng channel đã đóng thì mở lại channel
+	if !node.Client.IsChannelOpen() {
+		if err := node.Client.RecoverChannel(); err != nil {
+			p.recordFailure(node)
+			p.setNodeHealthy(node, false)
+			p.backoffProbe(node)
+			p.logger.Warn("Node %s channel is not usable: %v", p.redact(node.URL), err)
+			return
+		}
+	}
+
+	// Socket còn mở nhưng broker không trả lời (ví dụ bị treo)
+	if pingErr != nil && pinged == node.Client {
+		p.recordFailure(node)
+		p.setNodeHealthy(node, false)
+		p.backoffProbe(node)
+		p.logger.Warn("Node %s did not answer health check ping: %v", p.redact(node.URL), pingErr)
 		return
 	}
 
 	// Node đang healthy
+	p.resetProbe(node)
 	if !node.healthy {
-		node.healthy = true
-		p.logger.Info("Node %s is now healthy", node.URL)
+		p.setNodeHealthy(node, true)
+		p.healthy.notify()
+		p.logger.Info("Node %s is now healthy", p.redact(node.URL))
+		p.emitRecovery(node)
+	}
+}
+
+// pingNode ping broker qua client của node khi ActiveHealthCheck được bật và
+// trả về client đã ping. Client chưa có channel dùng được thì không ping, phần
+// còn lại của checkNodeHealth xử lý
+func (p *Pool) pingNode(node *NodeConnection) (*Client, error) {
+	if !p.config.ActiveHealthCheck {
+		return nil, nil
 	}
+
+	node.mutex.RLock()
+	client := node.Client
+	node.mutex.RUnlock()
+	if client == nil || !client.IsChannelOpen() {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, p.config.HealthCheckTimeout)
+	defer cancel()
+	return client, client.Ping(ctx)
+}
+
+// FirstConnectErrors trả về lỗi của lần connect đầu tiên theo URL node, chỉ gồm
+// các node mà lần connect đầu tiên thất bại. Connect chạy nền sau Start nên node
+// chưa kết thúc lần connect đầu tiên không có trong kết quả.
+func (p *Pool) FirstConnectErrors() map[string]error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	errs := make(map[string]error)
+	for _, node := range p.nodes {
+		node.mutex.RLock()
+		if node.firstErr != nil {
+			errs[node.URL] = node.firstErr
+		}
+		node.mutex.RUnlock()
+	}
+	return errs
+}
+
+// RecoveryEvents trả về channel nhận ID của node khi node chuyển từ unhealthy
+// sang healthy. Channel có buffer, event bị bỏ nếu consumer đọc chậm.
+func (p *Pool) RecoveryEvents() <-chan string {
+	return p.recoveries
+}
+
+// emitRecovery gửi recovery event của node, không block khi buffer đầy
+func (p *Pool) emitRecovery(node *NodeConnection) {
+	select {
+	case p.recoveries <- node.ID:
+	default:
+		p.logger.Debug("Dropped recovery event for node %s", p.redact(node.URL))
+	}
+}
+
+// HealthEvents trả về channel nhận event mỗi khi một node chuyển giữa healthy
+// và unhealthy. Channel có buffer, event bị bỏ nếu consumer đọc chậm để không
+// chặn health check.
+func (p *Pool) HealthEvents() <-chan NodeHealthEvent {
+	return p.healthEvents
+}
+
+// setNodeHealthy đổi trạng thái healthy của node và gửi NodeHealthEvent nếu
+// trạng thái thay đổi, phải giữ node.mutex
+func (p *Pool) setNodeHealthy(node *NodeConnection, healthy bool) {
+	if node.healthy == healthy {
+		return
+	}
+	node.setHealthy(healthy)
+
+	event := NodeHealthEvent{NodeID: node.ID, URL: p.redact(node.URL), Healthy: healthy, Time: time.Now()}
+	select {
+	case p.healthEvents <- event:
+	default:
+		p.logger.Debug("Dropped health event for node %s", p.redact(node.URL))
+	}
+}
+
+// backoffProbe tăng gấp đôi khoảng health check của node đang lỗi (tối đa
+// HealthCheckMaxInterval), phải giữ node.mutex
+func (p *Pool) backoffProbe(node *NodeConnection) {
+	if node.probeInterval == 0 {
+		node.probeInterval = p.config.HealthCheckInterval
+	} else {
+		node.probeInterval *= 2
+	}
+	if node.probeInterval > p.config.HealthCheckMaxInterval {
+		node.probeInterval = p.config.HealthCheckMaxInterval
+	}
+	node.nextProbe = time.Now().Add(node.probeInterval)
+}
+
+// resetProbe đưa khoảng health check của node về mặc định, phải giữ node.mutex
+func (p *Pool) resetProbe(node *NodeConnection) {
+	node.probeInterval = 0
+	node.nextProbe = time.Time{}
 }
 
 // GetStats lấy thống kê của pool
@@ -342,34 +1401,276 @@ func (p *Pool) GetStats() PoolStats {
 
 	stats := PoolStats{
 		TotalNodes:    len(p.nodes),
-		TotalRequests: p.totalRequests,
-		TotalFailures: p.totalFailures,
+		TotalRequests: atomic.LoadInt64(&p.totalRequests),
+		TotalFailures: atomic.LoadInt64(&p.totalFailures),
+		InFlight:      atomic.LoadInt64(&p.inFlight),
 		NodesStats:    make([]NodeStats, 0, len(p.nodes)),
 	}
 
 	for _, node := range p.nodes {
-		node.mutex.RLock()
-		nodeStat := NodeStats{
-			URL:       node.URL,
-			Healthy:   node.healthy,
-			Connected: node.Client != nil && node.Client.IsConnected(),
-			TotalUsed: node.totalUsed,
-			Failures:  node.failures,
-			Weight:    node.weight,
-			LastUsed:  node.lastUsed.Format(time.RFC3339),
-		}
-		node.mutex.RUnlock()
-
+		nodeStat := node.stats(p.config.ShowCredentials, p.config.FailureWindow)
 		if nodeStat.Healthy {
 			stats.HealthyNodes++
 		}
 
 		stats.NodesStats = append(stats.NodesStats, nodeStat)
 	}
+	setActualShares(stats.NodesStats)
 
 	return stats
 }
 
+// Config trả về bản sao cấu hình đang dùng của pool, sau khi đã áp dụng giá
+// trị mặc định. URLs là các node hiện có trong pool, credentials trong URL
+// (kể cả key của NodeIDs, NodeTiers) bị ẩn trừ khi bật ShowCredentials
+func (p *Pool) Config() PoolConfig {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	config := p.config
+	config.URLs = make([]string, 0, len(p.nodes))
+	for _, node := range p.nodes {
+		config.URLs = append(config.URLs, p.redact(node.URL))
+	}
+	if p.config.NodeIDs != nil {
+		config.NodeIDs = make(map[string]string, len(p.config.NodeIDs))
+		for url, id := range p.config.NodeIDs {
+			config.NodeIDs[p.redact(url)] = id
+		}
+	}
+	if p.config.NodeTiers != nil {
+		config.NodeTiers = make(map[string]NodeTier, len(p.config.NodeTiers))
+		for url, tier := range p.config.NodeTiers {
+			config.NodeTiers[p.redact(url)] = tier
+		}
+	}
+	if p.config.QoSProfiles != nil {
+		config.QoSProfiles = make(map[string]QoSProfile, len(p.config.QoSProfiles))
+		for name, profile := range p.config.QoSProfiles {
+			config.QoSProfiles[name] = profile
+		}
+	}
+	if config.Password != "" && !p.config.ShowCredentials {
+		config.Password = "****"
+	}
+	config.DefaultHeaders = mergeHeaders(p.config.DefaultHeaders, nil)
+	if p.config.AutoDeclareExchange != nil {
+		autoDeclare := *p.config.AutoDeclareExchange
+		config.AutoDeclareExchange = &autoDeclare
+	}
+	return config
+}
+
+// setActualShares tính ActualShare của mỗi node theo tổng TotalUsed của các node
+func setActualShares(nodesStats []NodeStats) {
+	var total int64
+	for _, nodeStat := range nodesStats {
+		total += nodeStat.TotalUsed
+	}
+	if total == 0 {
+		return
+	}
+	for i := range nodesStats {
+		nodesStats[i].ActualShare = float64(nodesStats[i].TotalUsed) / float64(total)
+	}
+}
+
+// NodeStat trả về thống kê của node theo URL
+func (p *Pool) NodeStat(url string) (NodeStats, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	index := -1
+	nodesStats := make([]NodeStats, len(p.nodes))
+	for i, node := range p.nodes {
+		nodesStats[i] = node.stats(p.config.ShowCredentials, p.config.FailureWindow)
+		if node.URL == url {
+			index = i
+		}
+	}
+	if index < 0 {
+		return NodeStats{}, false
+	}
+	setActualShares(nodesStats)
+	return nodesStats[index], true
+}
+
+// ConnectionInfo trả về thông số đã thỏa thuận của connection đến node
+func (p *Pool) ConnectionInfo(url string) (ConnectionInfo, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, node := range p.nodes {
+		if node.URL != url {
+			continue
+		}
+		node.mutex.RLock()
+		client := node.Client
+		node.mutex.RUnlock()
+		if client == nil {
+			return ConnectionInfo{}, fmt.Errorf("node %s is not connected", p.redact(url))
+		}
+		return client.ConnectionInfo()
+	}
+	return ConnectionInfo{}, fmt.Errorf("node not found: %s", p.redact(url))
+}
+
+// isCordoned node bị cordon thủ công hoặc bởi CordonCheck, phải giữ node.mutex
+func (node *NodeConnection) isCordoned() bool {
+	return node.cordoned || node.checkCordoned
+}
+
+// setHealthy cập nhật trạng thái healthy và cộng dồn thời gian unhealthy của
+// node từ lúc chuyển sang unhealthy đến khi phục hồi, phải giữ node.mutex
+func (node *NodeConnection) setHealthy(healthy bool) {
+	now := time.Now()
+	switch {
+	case node.healthy && !healthy:
+		node.downSince = now
+	case !node.healthy && healthy && !node.downSince.IsZero():
+		node.downtime += now.Sub(node.downSince)
+		node.downSince = time.Time{}
+		node.recoveredAt = now
+	}
+	node.healthy = healthy
+}
+
+// effectiveWeight trả về weight của node cho weighted round-robin, đã nhân
+// scale. Node vừa phục hồi nhận weight tăng tuyến tính trong warmUp để không
+// phải nhận đủ traffic ngay và lỗi lại. Phải giữ node.mutex
+func (node *NodeConnection) effectiveWeight(now time.Time, warmUp time.Duration, scale int) int {
+	weight := node.weight * scale
+	if warmUp <= 0 || node.recoveredAt.IsZero() || weight <= 0 {
+		return weight
+	}
+	elapsed := now.Sub(node.recoveredAt)
+	if elapsed >= warmUp {
+		return weight
+	}
+	ramped := int(int64(weight) * int64(elapsed) / int64(warmUp))
+	if ramped < 1 {
+		return 1
+	}
+	return ramped
+}
+
+// totalDowntime tổng thời gian unhealthy, gồm cả lần lỗi đang diễn ra, phải giữ node.mutex
+func (node *NodeConnection) totalDowntime() time.Duration {
+	if node.downSince.IsZero() {
+		return node.downtime
+	}
+	return node.downtime + time.Since(node.downSince)
+}
+
+// stats tạo NodeStats từ trạng thái hiện tại của node
+func (node *NodeConnection) stats(showCredentials bool, failureWindow time.Duration) NodeStats {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	var heartbeat, confirmLatency time.Duration
+	if node.Client != nil {
+		heartbeat = node.Client.NegotiatedHeartbeat()
+		confirmLatency = node.Client.ConfirmLatency()
+	}
+	url := node.URL
+	if !showCredentials {
+		url = redactURL(url)
+	}
+	avgLatency, p99Latency := node.latency.summary()
+	return NodeStats{
+		NodeID:    node.ID,
+		URL:       url,
+		Healthy:   node.healthy,
+		Connected: node.Client != nil && node.Client.IsConnected(),
+		TotalUsed: atomic.LoadInt64(&node.totalUsed),
+		Failures:  atomic.LoadInt64(&node.failures),
+		Weight:    node.weight,
+		Failed:    node.failed,
+		Tier:      node.tier,
+		Cordoned:  node.isCordoned(),
+		LastUsed:  node.lastUsed.Format(time.RFC3339),
+		Heartbeat: heartbeat,
+
+		TotalDowntime: node.totalDowntime(),
+
+		ConfirmLatency:  confirmLatency,
+		PublishAttempts: atomic.LoadInt64(&node.publishAttempts),
+		PublishFailures: atomic.LoadInt64(&node.publishFailures),
+		InFlight:        atomic.LoadInt64(&node.inFlight),
+		CheckoutLatency: node.checkoutLatency.get(),
+		AvgLatencyMs:    durationMillis(avgLatency),
+		P99LatencyMs:    durationMillis(p99Latency),
+		CloseErrorCodes: node.closeCodes.snapshot(),
+		RecentFailures:  node.recentFailures.count(time.Now(), failureWindow),
+		Circuit:         node.circuit,
+	}
+}
+
+// Shutdown đóng pool sau khi các operation in-flight (client từ AcquireClient
+// chưa release, Publish, PublishReliable) kết thúc và broker đã confirm các
+// message publish với confirm trên client của mọi node. Operation mới bị từ
+// chối ngay. Nếu ctx hết hạn trước, pool vẫn được đóng như Close và trả về lỗi
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.draining = true
+	p.mutex.Unlock()
+
+	p.logger.Info("Pool shutting down, waiting for %d in-flight operations", atomic.LoadInt64(&p.inFlight))
+	drainErr := p.waitIdle(ctx)
+	if drainErr != nil {
+		p.logger.Warn("Closing pool with operations still in flight: %v", drainErr)
+	} else if drainErr = p.waitConfirms(ctx); drainErr != nil {
+		p.logger.Warn("Closing pool with unconfirmed publishes: %v", drainErr)
+	}
+
+	if err := p.Close(); err != nil {
+		return err
+	}
+	return drainErr
+}
+
+// waitConfirms chờ confirm của các message đã publish với confirm trên client
+// của mọi node, hoặc đến khi ctx hết hạn
+func (p *Pool) waitConfirms(ctx context.Context) error {
+	p.mutex.RLock()
+	clients := make([]*Client, 0, len(p.nodes))
+	for _, node := range p.nodes {
+		node.mutex.RLock()
+		if node.Client != nil {
+			clients = append(clients, node.Client)
+		}
+		node.mutex.RUnlock()
+	}
+	p.mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := client.WaitForConfirms(ctx); err != nil {
+			return fmt.Errorf("confirms not drained before shutdown deadline: %w", err)
+		}
+	}
+	return nil
+}
+
+// waitIdle chờ đến khi không còn operation in-flight hoặc ctx hết hạn
+func (p *Pool) waitIdle(ctx context.Context) error {
+	for {
+		idle := p.idle.wait()
+		n := atomic.LoadInt64(&p.inFlight)
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return fmt.Errorf("shutdown timed out with %d operations in flight: %w", n, ctx.Err())
+		}
+	}
+}
+
 // Close đóng pool
 func (p *Pool) Close() error {
 	p.mutex.Lock()
@@ -382,6 +1683,9 @@ func (p *Pool) Close() error {
 	p.closed = true
 
 	// Hủy context
+	if p.stopParentWatch != nil {
+		p.stopParentWatch()
+	}
 	if p.cancel != nil {
 		p.cancel()
 	}
@@ -391,22 +1695,78 @@ func (p *Pool) Close() error {
 		p.healthTicker.Stop()
 	}
 
-	// Đóng tất cả nodes
-	var errs []error
+	ctx := context.Background()
+	if p.config.CloseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.CloseTimeout)
+		defer cancel()
+	}
+	if err := p.closeNodes(ctx); err != nil {
+		return err
+	}
+
+	p.logger.Info("Pool closed")
+	return nil
+}
+
+// closeNodes đóng client của mọi node song song. Nếu ctx hết hạn trước khi
+// đóng xong, các client còn lại bị bỏ lại (context của chúng đã bị hủy) và
+// trả về lỗi timeout
+func (p *Pool) closeNodes(ctx context.Context) error {
+	var (
+		wg      sync.WaitGroup
+		errsMu  sync.Mutex
+		errs    []error
+		pending = make(map[string]bool)
+	)
 	for _, node := range p.nodes {
-		if node.Client != nil {
-			if err := node.Client.Close(); err != nil {
-				errs = append(errs, fmt.Errorf("failed to close node %s: %v", node.URL, err))
-			}
+		node.mutex.RLock()
+		client := node.Client
+		node.mutex.RUnlock()
+		if client == nil {
+			continue
 		}
+
+		errsMu.Lock()
+		pending[node.URL] = true
+		errsMu.Unlock()
+		wg.Add(1)
+		go func(node *NodeConnection) {
+			defer wg.Done()
+			err := client.Close()
+
+			errsMu.Lock()
+			defer errsMu.Unlock()
+			delete(pending, node.URL)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to close node %s: %v", p.redact(node.URL), err))
+			}
+		}(node)
 	}
 
-	p.logger.Info("Pool closed")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errsMu.Lock()
+		defer errsMu.Unlock()
+		stuck := make([]string, 0, len(pending))
+		for url := range pending {
+			stuck = append(stuck, p.redact(url))
+		}
+		sort.Strings(stuck)
+		p.logger.Warn("Abandoned closing nodes %v: %v", stuck, ctx.Err())
+		return fmt.Errorf("timed out closing pool, nodes still closing: %v: %w", stuck, ctx.Err())
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during close: %v", errs)
 	}
-
 	return nil
 }
 
@@ -420,26 +1780,108 @@ func (p *Pool) SetNodeWeight(url string, weight int) error {
 			node.mutex.Lock()
 			node.weight = weight
 			node.mutex.Unlock()
-			p.logger.Info("Set weight for node %s to %d", url, weight)
+			p.logger.Info("Set weight for node %s to %d", p.redact(url), weight)
 			return nil
 		}
 	}
 
-	return fmt.Errorf("node not found: %s", url)
+	return fmt.Errorf("node not found: %s", p.redact(url))
 }
 
-// GetHealthyNodeCount trả về số lượng nodes đang healthy
+// SetNodeCordoned cordon hoặc bỏ cordon node. Node bị cordon vẫn giữ kết nối
+// nhưng không được chọn khi lấy client.
+func (p *Pool) SetNodeCordoned(url string, cordoned bool) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, node := range p.nodes {
+		if node.URL == url {
+			node.mutex.Lock()
+			node.cordoned = cordoned
+			node.mutex.Unlock()
+			p.logger.Info("Set cordoned for node %s to %v", p.redact(url), cordoned)
+			if !cordoned {
+				p.healthy.notify()
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("node not found: %s", p.redact(url))
+}
+
+// GetHealthyNodeCount trả về số node có thể được chọn ngay: healthy, không bị
+// cordon và thuộc tier đang dùng (node backup không được tính khi còn node
+// primary healthy), cùng tập node mà load balancing chọn
 func (p *Pool) GetHealthyNodeCount() int {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
+	return len(p.getHealthyNodes())
+}
+
+// AddNode thêm node vào pool lúc đang chạy, URL được kiểm tra như Validate.
+// Nếu pool đã Start, node được kết nối ngay trong nền và được chọn khi trở
+// thành healthy
+func (p *Pool) AddNode(url string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	count := 0
+	if p.closed {
+		return fmt.Errorf("pool is closed")
+	}
 	for _, node := range p.nodes {
-		node.mutex.RLock()
-		if node.healthy && node.Client != nil && node.Client.IsConnected() {
-			count++
+		if node.URL == url {
+			return fmt.Errorf("node already exists: %s", p.redact(url))
 		}
-		node.mutex.RUnlock()
 	}
-	return count
+	if err := checkURL(len(p.nodes), url, true, map[string]bool{}); err != nil {
+		return err
+	}
+
+	node := p.newNode(url)
+	// Tạo slice mới để không ảnh hưởng các snapshot đang được duyệt
+	p.nodes = append(p.nodes[:len(p.nodes):len(p.nodes)], node)
+	if p.healthTicker != nil {
+		p.startConnect(node)
+	}
+
+	p.logger.Info("Added node %s", p.redact(url))
+	return nil
+}
+
+// RemoveNode xóa node khỏi pool. Context của client bị hủy trước để các
+// publish đang chạy trên node dừng ngay, sau đó client được đóng.
+func (p *Pool) RemoveNode(url string) error {
+	p.mutex.Lock()
+	var removed *NodeConnection
+	for i, node := range p.nodes {
+		if node.URL == url {
+			removed = node
+			// Tạo slice mới để không ảnh hưởng các snapshot đang được duyệt
+			p.nodes = append(p.nodes[:i:i], p.nodes[i+1:]...)
+			break
+		}
+	}
+	p.mutex.Unlock()
+
+	if removed == nil {
+		return fmt.Errorf("node not found: %s", p.redact(url))
+	}
+
+	removed.mutex.Lock()
+	removed.removed = true
+	p.setNodeHealthy(removed, false)
+	client := removed.Client
+	removed.Client = nil
+	removed.mutex.Unlock()
+
+	// Close hủy context của client trước khi đóng connection
+	if client != nil {
+		if err := client.Close(); err != nil {
+			p.logger.Warn("Error closing removed node %s: %v", p.redact(url), err)
+		}
+	}
+
+	p.logger.Info("Removed node %s", p.redact(url))
+	return nil
 }
@@ -0,0 +1,84 @@
+// Package prommetrics xuất thống kê của bunnyhop.Pool dưới dạng metrics Prometheus.
+// Nằm trong package riêng để package bunnyhop không phụ thuộc client_golang.
+package prommetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vanduc0209/bunnyhop"
+)
+
+// Collector đọc PoolStats của pool ở mỗi lần scrape
+type Collector struct {
+	pool *bunnyhop.Pool
+
+	requests     *prometheus.Desc
+	failures     *prometheus.Desc
+	inFlight     *prometheus.Desc
+	nodes        *prometheus.Desc
+	healthyNodes *prometheus.Desc
+	nodeUsed     *prometheus.Desc
+	nodeFailures *prometheus.Desc
+	nodeHealthy  *prometheus.Desc
+	nodeInFlight *prometheus.Desc
+}
+
+// NewCollector tạo collector cho pool, đăng ký bằng registerer.MustRegister
+func NewCollector(pool *bunnyhop.Pool) *Collector {
+	nodeLabels := []string{"node_id"}
+	return &Collector{
+		pool: pool,
+		requests: prometheus.NewDesc("bunnyhop_pool_requests_total",
+			"Client selections made by the pool.", nil, nil),
+		failures: prometheus.NewDesc("bunnyhop_pool_failures_total",
+			"Client selections that failed.", nil, nil),
+		inFlight: prometheus.NewDesc("bunnyhop_pool_in_flight",
+			"Clients currently checked out.", nil, nil),
+		nodes: prometheus.NewDesc("bunnyhop_pool_nodes",
+			"Nodes configured in the pool.", nil, nil),
+		healthyNodes: prometheus.NewDesc("bunnyhop_pool_healthy_nodes",
+			"Nodes currently healthy.", nil, nil),
+		nodeUsed: prometheus.NewDesc("bunnyhop_node_used_total",
+			"Times the node was selected.", nodeLabels, nil),
+		nodeFailures: prometheus.NewDesc("bunnyhop_node_failures_total",
+			"Connect failures of the node.", nodeLabels, nil),
+		nodeHealthy: prometheus.NewDesc("bunnyhop_node_healthy",
+			"1 if the node is healthy, 0 otherwise.", nodeLabels, nil),
+		nodeInFlight: prometheus.NewDesc("bunnyhop_node_in_flight",
+			"Checked out clients and running publishes on the node.", nodeLabels, nil),
+	}
+}
+
+// Describe implement prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.failures
+	ch <- c.inFlight
+	ch <- c.nodes
+	ch <- c.healthyNodes
+	ch <- c.nodeUsed
+	ch <- c.nodeFailures
+	ch <- c.nodeHealthy
+	ch <- c.nodeInFlight
+}
+
+// Collect implement prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.GetStats()
+
+	ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(stats.TotalRequests))
+	ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(stats.TotalFailures))
+	ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(stats.InFlight))
+	ch <- prometheus.MustNewConstMetric(c.nodes, prometheus.GaugeValue, float64(stats.TotalNodes))
+	ch <- prometheus.MustNewConstMetric(c.healthyNodes, prometheus.GaugeValue, float64(stats.HealthyNodes))
+
+	for _, node := range stats.NodesStats {
+		healthy := 0.0
+		if node.Healthy {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.nodeUsed, prometheus.CounterValue, float64(node.TotalUsed), node.NodeID)
+		ch <- prometheus.MustNewConstMetric(c.nodeFailures, prometheus.CounterValue, float64(node.Failures), node.NodeID)
+		ch <- prometheus.MustNewConstMetric(c.nodeHealthy, prometheus.GaugeValue, healthy, node.NodeID)
+		ch <- prometheus.MustNewConstMetric(c.nodeInFlight, prometheus.GaugeValue, float64(node.InFlight), node.NodeID)
+	}
+}
@@ -0,0 +1,109 @@
+package bunnyhop
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// QueueOptions tùy chọn khai báo queue cho các helper
+type QueueOptions struct {
+	AutoDelete bool       // Xóa queue khi consumer cuối cùng hủy
+	Exclusive  bool       // Queue chỉ dùng cho connection hiện tại
+	Args       amqp.Table // Tham số x-arguments bổ sung
+
+	// Lazy lưu message xuống đĩa sớm (x-queue-mode: lazy) để giảm bộ nhớ
+	// với backlog lớn, chỉ áp dụng cho classic queue
+	Lazy bool
+
+	MaxPriority        int           // x-max-priority (1-255), 0 là queue không ưu tiên
+	MessageTTL         time.Duration // x-message-ttl, làm tròn xuống mili giây, 0 là không giới hạn
+	MaxLength          int           // x-max-length, 0 là không giới hạn
+	DeadLetterExchange string        // x-dead-letter-exchange cho message bị reject hoặc hết hạn
+}
+
+// args dựng bảng x-arguments từ các tùy chọn
+func (o QueueOptions) args() (amqp.Table, error) {
+	args := amqp.Table{}
+	for k, v := range o.Args {
+		args[k] = v
+	}
+
+	if o.Lazy {
+		if queueType, _ := args["x-queue-type"].(string); queueType != "" && queueType != "classic" {
+			return nil, fmt.Errorf("lazy mode is not supported for %s queues", queueType)
+		}
+		args["x-queue-mode"] = "lazy"
+	}
+
+	// Các trường có kiểu ghi đè giá trị cùng tên trong Args
+	if o.MaxPriority < 0 || o.MaxPriority > 255 {
+		return nil, fmt.Errorf("max priority must be between 0 and 255, got %d", o.MaxPriority)
+	}
+	if o.MaxPriority > 0 {
+		args["x-max-priority"] = int32(o.MaxPriority)
+	}
+	if o.MessageTTL < 0 {
+		return nil, fmt.Errorf("message TTL must not be negative, got %v", o.MessageTTL)
+	}
+	if o.MessageTTL > 0 {
+		args["x-message-ttl"] = o.MessageTTL.Milliseconds()
+	}
+	if o.MaxLength < 0 {
+		return nil, fmt.Errorf("max length must not be negative, got %d", o.MaxLength)
+	}
+	if o.MaxLength > 0 {
+		args["x-max-length"] = int64(o.MaxLength)
+	}
+	if o.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = o.DeadLetterExchange
+	}
+
+	return args, nil
+}
+
+// DeclareQueueWithOptions khai báo queue durable với x-arguments dựng từ opts.
+// Tương đương DeclareQueue(name, true, opts.AutoDelete, opts.Exclusive, args)
+// nên queue cũng được ghi vào topology để khai báo lại sau reconnect. Message
+// được ưu tiên theo amqp.Publishing.Priority khi opts.MaxPriority > 0.
+func (c *Client) DeclareQueueWithOptions(name string, opts QueueOptions) (amqp.Queue, error) {
+	args, err := opts.args()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	return c.DeclareQueue(name, true, opts.AutoDelete, opts.Exclusive, args)
+}
+
+// DeclareWorkQueue khai báo queue durable dùng cho work queue, có thể publish
+// trực tiếp qua default exchange với tên queue làm routing key:
+//
+//	client.PublishMessage("", name, false, false, msg)
+func (c *Client) DeclareWorkQueue(name string, opts QueueOptions) (amqp.Queue, error) {
+	return c.DeclareQueueWithOptions(name, opts)
+}
+
+// DeclareQueueWithDLX khai báo queue có x-dead-letter-exchange là dlx và, nếu
+// dlxRoutingKey khác rỗng, x-dead-letter-routing-key (rỗng thì message giữ
+// routing key gốc). dlx rỗng là default exchange, khi đó cần dlxRoutingKey
+// làm tên queue đích. declareDLX khai báo trước dlx là direct exchange có cùng
+// durable với queue.
+func (c *Client) DeclareQueueWithDLX(name, dlx, dlxRoutingKey string, durable, declareDLX bool) (amqp.Queue, error) {
+	if dlx == "" && dlxRoutingKey == "" {
+		return amqp.Queue{}, fmt.Errorf("dead letter routing key is required when using the default exchange")
+	}
+	if declareDLX {
+		if dlx == "" {
+			return amqp.Queue{}, fmt.Errorf("cannot declare the default exchange as dead letter exchange")
+		}
+		if err := c.DeclareExchange(dlx, amqp.ExchangeDirect, durable, false, false, nil); err != nil {
+			return amqp.Queue{}, fmt.Errorf("failed to declare dead letter exchange: %w", err)
+		}
+	}
+
+	args := amqp.Table{"x-dead-letter-exchange": dlx}
+	if dlxRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = dlxRoutingKey
+	}
+	return c.DeclareQueue(name, durable, false, false, args)
+}
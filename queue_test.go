@@ -0,0 +1,113 @@
+package bunnyhop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeclareWorkQueue_DeclaresDurableQueue(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	queue, err := client.DeclareWorkQueue("emails", QueueOptions{
+		Args: amqp.Table{"x-message-ttl": int32(60000)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "emails", queue.Name)
+
+	declared := dialer.last().channel(0).declaredQueues()
+	require.Len(t, declared, 1)
+	assert.Equal(t, fakeQueueDeclare{
+		Name:    "emails",
+		Durable: true,
+		Args:    amqp.Table{"x-message-ttl": int32(60000)},
+	}, declared[0])
+}
+
+func TestQueueOptions_Lazy(t *testing.T) {
+	args, err := QueueOptions{Lazy: true}.args()
+	require.NoError(t, err)
+	assert.Equal(t, "lazy", args["x-queue-mode"])
+
+	_, err = QueueOptions{Lazy: true, Args: amqp.Table{"x-queue-type": "quorum"}}.args()
+	assert.Error(t, err)
+
+	args, err = QueueOptions{}.args()
+	require.NoError(t, err)
+	assert.NotContains(t, args, "x-queue-mode")
+}
+
+func TestDeclareQueueWithOptions_BuildsArgs(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	_, err := client.DeclareQueueWithOptions("jobs", QueueOptions{
+		MaxPriority:        10,
+		MessageTTL:         90 * time.Second,
+		MaxLength:          1000,
+		DeadLetterExchange: "jobs.dlx",
+		Args:               amqp.Table{"x-max-length": int64(5), "x-overflow": "reject-publish"},
+	})
+	require.NoError(t, err)
+
+	declared := dialer.last().channel(0).declaredQueues()
+	require.Len(t, declared, 1)
+	assert.True(t, declared[0].Durable)
+	assert.Equal(t, amqp.Table{
+		"x-max-priority":         int32(10),
+		"x-message-ttl":          int64(90000),
+		"x-max-length":           int64(1000),
+		"x-dead-letter-exchange": "jobs.dlx",
+		"x-overflow":             "reject-publish",
+	}, declared[0].Args)
+	assert.NoError(t, declared[0].Args.Validate())
+
+	// Giá trị không hợp lệ bị từ chối trước khi khai báo
+	_, err = client.DeclareQueueWithOptions("jobs", QueueOptions{MaxPriority: 256})
+	assert.Error(t, err)
+	_, err = client.DeclareQueueWithOptions("jobs", QueueOptions{MessageTTL: -time.Second})
+	assert.Error(t, err)
+	assert.Len(t, dialer.last().channel(0).declaredQueues(), 1)
+}
+
+func TestDeclareQueueWithDLX(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+	ch := dialer.last().channel(0)
+
+	_, err := client.DeclareQueueWithDLX("orders", "orders.dlx", "orders.dead", true, true)
+	require.NoError(t, err)
+	assert.Equal(t, []ExchangeDeclaration{{Name: "orders.dlx", Kind: amqp.ExchangeDirect, Durable: true}}, ch.exchanges)
+
+	// Không khai báo DLX, message giữ routing key gốc
+	_, err = client.DeclareQueueWithDLX("audit", "audit.dlx", "", false, false)
+	require.NoError(t, err)
+	assert.Len(t, ch.exchanges, 1)
+
+	declared := ch.declaredQueues()
+	require.Len(t, declared, 2)
+	assert.Equal(t, fakeQueueDeclare{
+		Name:    "orders",
+		Durable: true,
+		Args:    amqp.Table{"x-dead-letter-exchange": "orders.dlx", "x-dead-letter-routing-key": "orders.dead"},
+	}, declared[0])
+	assert.Equal(t, fakeQueueDeclare{
+		Name: "audit",
+		Args: amqp.Table{"x-dead-letter-exchange": "audit.dlx"},
+	}, declared[1])
+
+	// Default exchange cần routing key và không thể khai báo
+	_, err = client.DeclareQueueWithDLX("orders", "", "", true, false)
+	assert.Error(t, err)
+	_, err = client.DeclareQueueWithDLX("orders", "", "orders.dead", true, true)
+	assert.Error(t, err)
+	assert.Len(t, ch.declaredQueues(), 2)
+}
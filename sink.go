@@ -0,0 +1,102 @@
+package bunnyhop
+
+import (
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// FailedPublishSink nhận các message publish thất bại (nack, bị return hoặc lỗi publish)
+type FailedPublishSink interface {
+	Record(exchange, routingKey string, msg amqp.Publishing, reason string)
+}
+
+// noopFailedPublishSink bỏ qua mọi message, là sink mặc định
+type noopFailedPublishSink struct{}
+
+func (noopFailedPublishSink) Record(string, string, amqp.Publishing, string) {}
+
+// FailedPublish một message publish thất bại đã được ghi lại
+type FailedPublish struct {
+	Exchange   string
+	RoutingKey string
+	Msg        amqp.Publishing
+	Reason     string
+}
+
+// MemoryFailedPublishSink lưu message publish thất bại trong bộ nhớ, dùng cho test
+type MemoryFailedPublishSink struct {
+	mutex   sync.Mutex
+	records []FailedPublish
+}
+
+// NewMemoryFailedPublishSink tạo sink trong bộ nhớ
+func NewMemoryFailedPublishSink() *MemoryFailedPublishSink {
+	return &MemoryFailedPublishSink{}
+}
+
+// Record ghi lại một message publish thất bại
+func (s *MemoryFailedPublishSink) Record(exchange, routingKey string, msg amqp.Publishing, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = append(s.records, FailedPublish{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Msg:        msg,
+		Reason:     reason,
+	})
+}
+
+// Records trả về bản sao các message đã ghi lại
+func (s *MemoryFailedPublishSink) Records() []FailedPublish {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]FailedPublish(nil), s.records...)
+}
+
+// watchReturns chuyển các message bị broker return vào FailedPublishSink và
+// return handler. Được đăng ký trên mọi channel publish, kể cả sau reconnect
+func (c *Client) watchReturns(returns chan amqp.Return) {
+	for r := range returns {
+		c.logger().Warn("Message returned by broker: exchange=%s routing_key=%s reply=%d %s",
+			r.Exchange, r.RoutingKey, r.ReplyCode, r.ReplyText)
+		c.config.FailedPublishSink.Record(r.Exchange, r.RoutingKey, returnToPublishing(r),
+			fmt.Sprintf("returned: %d %s", r.ReplyCode, r.ReplyText))
+
+		c.returnMutex.Lock()
+		handler := c.returnHandler
+		c.returnMutex.Unlock()
+		if handler != nil {
+			handler(r)
+		}
+	}
+}
+
+// SetReturnHandler đặt callback nhận mọi message bị broker return (publish
+// mandatory không route được). Handler được giữ qua các lần reconnect, nil để gỡ
+func (c *Client) SetReturnHandler(handler func(amqp.Return)) {
+	c.returnMutex.Lock()
+	defer c.returnMutex.Unlock()
+	c.returnHandler = handler
+}
+
+// returnToPublishing dựng lại amqp.Publishing từ message bị return
+func returnToPublishing(r amqp.Return) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         r.Headers,
+		ContentType:     r.ContentType,
+		ContentEncoding: r.ContentEncoding,
+		DeliveryMode:    r.DeliveryMode,
+		Priority:        r.Priority,
+		CorrelationId:   r.CorrelationId,
+		ReplyTo:         r.ReplyTo,
+		Expiration:      r.Expiration,
+		MessageId:       r.MessageId,
+		Timestamp:       r.Timestamp,
+		Type:            r.Type,
+		UserId:          r.UserId,
+		AppId:           r.AppId,
+		Body:            r.Body,
+	}
+}
@@ -0,0 +1,97 @@
+package bunnyhop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedPublishSink_RecordsNack(t *testing.T) {
+	sink := NewMemoryFailedPublishSink()
+	client, dialer := newFakeClient(Config{FailedPublishSink: sink})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.PublishWithConfirm(context.Background(), "orders", "created", false,
+			amqp.Publishing{Body: []byte("payload")})
+	}()
+
+	conn := dialer.last()
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.channels) == 2 && conn.channels[1].publishedCount() == 1
+	}, time.Second, 5*time.Millisecond)
+	conn.channel(1).sendConfirm(1, false)
+
+	require.Error(t, <-done)
+
+	records := sink.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "orders", records[0].Exchange)
+	assert.Equal(t, "created", records[0].RoutingKey)
+	assert.Equal(t, []byte("payload"), records[0].Msg.Body)
+	assert.Equal(t, "nacked by broker", records[0].Reason)
+}
+
+func TestFailedPublishSink_RecordsReturnAndPublishError(t *testing.T) {
+	sink := NewMemoryFailedPublishSink()
+	client, dialer := newFakeClient(Config{FailedPublishSink: sink})
+	require.NoError(t, client.Connect(context.Background()))
+
+	dialer.last().channel(0).sendReturn(amqp.Return{
+		ReplyCode:  312,
+		ReplyText:  "NO_ROUTE",
+		Exchange:   "orders",
+		RoutingKey: "missing",
+		Body:       []byte("lost"),
+	})
+	require.Eventually(t, func() bool { return len(sink.Records()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "returned: 312 NO_ROUTE", sink.Records()[0].Reason)
+	assert.Equal(t, []byte("lost"), sink.Records()[0].Msg.Body)
+
+	require.NoError(t, client.Close())
+	err := client.PublishMessage("orders", "created", false, false, amqp.Publishing{})
+	require.Error(t, err)
+
+	records := sink.Records()
+	require.Len(t, records, 2)
+	assert.Contains(t, records[1].Reason, "publish error")
+}
+
+func TestClient_ReturnHandlerReceivesMandatoryReturns(t *testing.T) {
+	client, dialer := newFakeClient(Config{})
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Close()
+
+	returned := make(chan amqp.Return, 2)
+	client.SetReturnHandler(func(r amqp.Return) { returned <- r })
+
+	require.NoError(t, client.PublishMessage("orders", "missing", true, false, amqp.Publishing{Body: []byte("lost")}))
+	conn := dialer.last()
+	conn.channel(0).sendReturn(amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", Exchange: "orders", RoutingKey: "missing", Body: []byte("lost")})
+	select {
+	case r := <-returned:
+		assert.Equal(t, "missing", r.RoutingKey)
+		assert.Equal(t, []byte("lost"), r.Body)
+	case <-time.After(time.Second):
+		t.Fatal("return handler was not called")
+	}
+
+	// Handler vẫn nhận return trên channel được mở lại
+	conn.channel(0).Close()
+	require.NoError(t, client.RecoverChannel())
+	conn.channel(1).sendReturn(amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", RoutingKey: "again"})
+	select {
+	case r := <-returned:
+		assert.Equal(t, "again", r.RoutingKey)
+	case <-time.After(time.Second):
+		t.Fatal("return handler was not called after channel recovery")
+	}
+}
@@ -0,0 +1,156 @@
+package bunnyhop
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology các exchange, queue và binding đã khai báo qua client,
+// có thể marshal sang JSON để sao lưu
+type Topology struct {
+	Exchanges []ExchangeDeclaration `json:"exchanges"`
+	Queues    []QueueDeclaration    `json:"queues"`
+	Bindings  []BindingDeclaration  `json:"bindings"`
+}
+
+// ExchangeDeclaration một lần khai báo exchange
+type ExchangeDeclaration struct {
+	Name       string     `json:"name"`
+	Kind       string     `json:"kind"`
+	Durable    bool       `json:"durable"`
+	AutoDelete bool       `json:"auto_delete"`
+	Internal   bool       `json:"internal"`
+	Args       amqp.Table `json:"args,omitempty"`
+}
+
+// QueueDeclaration một lần khai báo queue
+type QueueDeclaration struct {
+	Name       string     `json:"name"`
+	Durable    bool       `json:"durable"`
+	AutoDelete bool       `json:"auto_delete"`
+	Exclusive  bool       `json:"exclusive"`
+	Args       amqp.Table `json:"args,omitempty"`
+}
+
+// BindingDeclaration một binding giữa queue và exchange
+type BindingDeclaration struct {
+	Queue      string     `json:"queue"`
+	RoutingKey string     `json:"routing_key"`
+	Exchange   string     `json:"exchange"`
+	Args       amqp.Table `json:"args,omitempty"`
+}
+
+// topologyRegistry ghi lại các khai báo theo thứ tự, khai báo lại cùng tên sẽ ghi đè
+type topologyRegistry struct {
+	mutex    sync.Mutex
+	topology Topology
+}
+
+func (r *topologyRegistry) recordExchange(d ExchangeDeclaration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, existing := range r.topology.Exchanges {
+		if existing.Name == d.Name {
+			r.topology.Exchanges[i] = d
+			return
+		}
+	}
+	r.topology.Exchanges = append(r.topology.Exchanges, d)
+}
+
+func (r *topologyRegistry) recordQueue(d QueueDeclaration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, existing := range r.topology.Queues {
+		if existing.Name == d.Name {
+			r.topology.Queues[i] = d
+			return
+		}
+	}
+	r.topology.Queues = append(r.topology.Queues, d)
+}
+
+func (r *topologyRegistry) recordBinding(d BindingDeclaration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, existing := range r.topology.Bindings {
+		if existing.Queue == d.Queue && existing.RoutingKey == d.RoutingKey && existing.Exchange == d.Exchange {
+			r.topology.Bindings[i] = d
+			return
+		}
+	}
+	r.topology.Bindings = append(r.topology.Bindings, d)
+}
+
+// snapshot trả về bản sao topology đã ghi lại
+func (r *topologyRegistry) snapshot() Topology {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return Topology{
+		Exchanges: append([]ExchangeDeclaration(nil), r.topology.Exchanges...),
+		Queues:    append([]QueueDeclaration(nil), r.topology.Queues...),
+		Bindings:  append([]BindingDeclaration(nil), r.topology.Bindings...),
+	}
+}
+
+// ExportTopology trả về các khai báo client đã thực hiện
+func (c *Client) ExportTopology() Topology {
+	return c.topology.snapshot()
+}
+
+// ApplyTopology khai báo lại toàn bộ topology theo thứ tự exchange, queue, binding
+func (c *Client) ApplyTopology(t Topology) error {
+	for _, e := range t.Exchanges {
+		if err := c.DeclareExchange(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, normalizeTable(e.Args)); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %v", e.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := c.DeclareQueue(q.Name, q.Durable, q.AutoDelete, q.Exclusive, normalizeTable(q.Args)); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %v", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := c.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, normalizeTable(b.Args)); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %v", b.Queue, b.Exchange, err)
+		}
+	}
+	return nil
+}
+
+// replayTopology khai báo lại topology đã ghi lại sau khi reconnect, để queue
+// và exchange không durable tồn tại trước khi consumer được subscribe lại
+func (c *Client) replayTopology() {
+	if c.config.DisableTopologyReplay {
+		return
+	}
+	t := c.topology.snapshot()
+	if len(t.Exchanges) == 0 && len(t.Queues) == 0 && len(t.Bindings) == 0 {
+		return
+	}
+	if err := c.ApplyTopology(t); err != nil {
+		c.logger().Error("Failed to replay topology after reconnect: %v", err)
+		return
+	}
+	c.logger().Info("Replayed topology after reconnect: %d exchanges, %d queues, %d bindings",
+		len(t.Exchanges), len(t.Queues), len(t.Bindings))
+}
+
+// normalizeTable chuyển số nguyên bị JSON decode thành float64 về int64,
+// vì broker yêu cầu kiểu số nguyên cho các tham số như x-message-ttl
+func normalizeTable(t amqp.Table) amqp.Table {
+	if t == nil {
+		return nil
+	}
+	normalized := make(amqp.Table, len(t))
+	for k, v := range t {
+		if f, ok := v.(float64); ok && f == math.Trunc(f) {
+			v = int64(f)
+		}
+		normalized[k] = v
+	}
+	return normalized
+}
@@ -0,0 +1,94 @@
+package bunnyhop
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopology_ExportAndApplyRoundTrip(t *testing.T) {
+	source, _ := newFakeClient(Config{})
+	require.NoError(t, source.Connect(context.Background()))
+	defer source.Close()
+
+	require.NoError(t, source.DeclareExchange("orders", "topic", true, false, false, nil))
+	_, err := source.DeclareQueue("orders.created", true, false, false, amqp.Table{"x-message-ttl": int64(60000)})
+	require.NoError(t, err)
+	require.NoError(t, source.QueueBind("orders.created", "order.created", "orders", false, nil))
+
+	// Server-named queue không được ghi lại
+	_, err = source.DeclareQueue("", false, true, true, nil)
+	require.NoError(t, err)
+
+	exported := source.ExportTopology()
+	require.Len(t, exported.Exchanges, 1)
+	require.Len(t, exported.Queues, 1)
+	require.Len(t, exported.Bindings, 1)
+
+	data, err := json.Marshal(exported)
+	require.NoError(t, err)
+	var restored Topology
+	require.NoError(t, json.Unmarshal(data, &restored))
+
+	target, dialer := newFakeClient(Config{})
+	require.NoError(t, target.Connect(context.Background()))
+	defer target.Close()
+	require.NoError(t, target.ApplyTopology(restored))
+
+	ch := dialer.last().channel(0)
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	assert.Equal(t, []ExchangeDeclaration{{Name: "orders", Kind: "topic", Durable: true}}, ch.exchanges)
+	require.Len(t, ch.queues, 1)
+	assert.Equal(t, "orders.created", ch.queues[0].Name)
+	assert.Equal(t, amqp.Table{"x-message-ttl": int64(60000)}, ch.queues[0].Args)
+	assert.Equal(t, []BindingDeclaration{{Queue: "orders.created", RoutingKey: "order.created", Exchange: "orders"}}, ch.bindings)
+
+	assert.Equal(t, exported, target.ExportTopology())
+}
+
+func TestTopology_ReplayedAfterReconnect(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		client, dialer := newFakeClient(Config{
+			ReconnectInterval:     time.Millisecond,
+			MinReconnectInterval:  time.Millisecond,
+			DisableTopologyReplay: disabled,
+			Logger:                &captureLogger{},
+		})
+		require.NoError(t, client.ConnectOnce(context.Background()))
+
+		require.NoError(t, client.DeclareExchange("events", "fanout", false, true, false, nil))
+		_, err := client.DeclareQueue("events.audit", false, true, false, nil)
+		require.NoError(t, err)
+		require.NoError(t, client.QueueBind("events.audit", "", "events", false, nil))
+
+		// Mô phỏng mất connection rồi reconnect
+		first := dialer.last()
+		first.closeWithError(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED"})
+		client.mutex.Lock()
+		client.connected = false
+		client.mutex.Unlock()
+		client.reconnect()
+		require.NotSame(t, first, dialer.last())
+
+		ch := dialer.last().channel(0)
+		ch.mu.Lock()
+		if disabled {
+			assert.Empty(t, ch.exchanges)
+			assert.Empty(t, ch.queues)
+			assert.Empty(t, ch.bindings)
+		} else {
+			assert.Equal(t, []ExchangeDeclaration{{Name: "events", Kind: "fanout", AutoDelete: true}}, ch.exchanges)
+			require.Len(t, ch.queues, 1)
+			assert.Equal(t, "events.audit", ch.queues[0].Name)
+			assert.Equal(t, []BindingDeclaration{{Queue: "events.audit", Exchange: "events"}}, ch.bindings)
+		}
+		ch.mu.Unlock()
+		client.Close()
+	}
+}
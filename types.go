@@ -1,19 +1,188 @@
 package bunnyhop
 
 import (
+	"crypto/tls"
 	"sync"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // PoolConfig cấu hình cho Pool Client
 type PoolConfig struct {
-	URLs                []string      // Danh sách URLs của các node RabbitMQ
-	ReconnectInterval   time.Duration // Thời gian chờ giữa các lần reconnect
-	MaxReconnectAttempt int           // Số lần thử reconnect tối đa
-	HealthCheckInterval time.Duration // Thời gian giữa các lần health check
-	LoadBalanceStrategy LoadBalanceStrategy
-	DebugLog            bool   // Bật/tắt debug log
-	Logger              Logger // Custom logger interface
+	URLs                     []string      // Danh sách URLs của các node RabbitMQ
+	ReconnectInterval        time.Duration // Thời gian chờ giữa các lần reconnect
+	MinReconnectInterval     time.Duration // Khoảng chờ reconnect tối thiểu, mặc định 100ms
+	MaxReconnectAttempt      int           // Số lần thử reconnect tối đa
+	HealthCheckInterval      time.Duration // Thời gian giữa các lần health check
+	HealthCheckMaxInterval   time.Duration // Khoảng health check tối đa cho node đang lỗi (tăng gấp đôi mỗi lần lỗi)
+	HealthCheckConcurrency   int           // Số health check chạy đồng thời tối đa, 0 là không giới hạn
+	ActiveHealthCheck        bool          // Health check ping broker trên channel dùng chung (Client.Ping), không chỉ kiểm tra socket
+	HealthCheckTimeout       time.Duration // Thời gian chờ broker trả lời ping của ActiveHealthCheck, mặc định 5s
+	LoadBalanceStrategy      LoadBalanceStrategy
+	DebugLog                 bool                  // Bật/tắt debug log
+	Logger                   Logger                // Custom logger interface
+	QoSProfiles              map[string]QoSProfile // Các QoS profile áp dụng cho client của mỗi node
+	DefaultHeaders           amqp.Table            // Headers gắn vào mọi message publish qua client của pool
+	FailedPublishSink        FailedPublishSink     // Nơi ghi lại message publish thất bại
+	OnChannelClose           func(*amqp.Error)     // Callback khi một channel của client bị đóng bất thường
+	GenerateMessageID        bool                  // PublishWithIDs tự sinh UUID làm message id khi để trống
+	QoSFailurePolicy         QoSFailurePolicy      // Cách xử lý khi thiết lập QoS thất bại
+	DrainTimeout             time.Duration         // Thời gian chờ handler của consumer xử lý xong khi đóng client, mặc định 30s
+	CloseTimeout             time.Duration         // Thời gian tối đa Close chờ đóng connection của các node, 0 là không giới hạn
+	FailureWindow            time.Duration         // Cửa sổ đếm lỗi gần đây của node cho LeastFailures, mặc định 1 phút
+	AutoDeclareExchange      *ExchangeAutoDeclare  // Nếu khác nil, exchange đích được tự khai báo khi publish
+	NodeIDs                  map[string]string     // ID ổn định theo URL node, mặc định là hash của host:port/vhost
+	ShowCredentials          bool                  // Không ẩn credentials của URL trong log và NodeStats.URL
+	NodeTiers                map[string]NodeTier   // Tier theo URL node, mặc định là PrimaryTier
+	ReconnectLogInterval     time.Duration         // Khoảng tối thiểu giữa các lần log lặp lại cùng một lỗi connect của node, mặc định 1 phút
+	AllowUnlimitedPrefetch   bool                  // Không cảnh báo khi QoS có prefetch 0 (không giới hạn)
+	LatencyRecorder          LatencyRecorder       // Nhận latency của các operation trên mọi node
+	CordonCheck              func(url string) bool // Được health check gọi, true thì node bị cordon (không được chọn)
+	DryRun                   bool                  // Publish chỉ kiểm tra và cập nhật thống kê, không gửi đến broker
+	Purpose                  ConnectionPurpose     // Mục đích của connection, mặc định PurposeBoth
+	Keepalive                time.Duration         // Khoảng giữa các lần probe keepalive trên connection của mỗi node, 0 là tắt
+	Codec                    Codec                 // Codec mã hóa/giải mã body cho client của mỗi node, mặc định JSONCodec
+	TLSConfig                *tls.Config           // Cấu hình TLS (CA, client cert) cho URL amqps:// của các node
+	Heartbeat                time.Duration         // Heartbeat yêu cầu broker cho connection của mỗi node, 0 là mặc định của amqp091
+	DialTimeout              time.Duration         // Timeout của TCP dial và handshake đến mỗi node, 0 là mặc định của amqp091
+	PrefetchCount            int                   // Prefetch count của channel dùng chung của mỗi node, mặc định 1
+	PrefetchSize             int                   // Prefetch size (bytes) của channel dùng chung của mỗi node, 0 là không giới hạn
+	GlobalQos                bool                  // Áp dụng QoS của channel dùng chung cho toàn connection
+	Username                 string                // Nếu khác rỗng, thay username trong URL của mọi node khi dial
+	Password                 string                // Nếu khác rỗng, thay password trong URL của mọi node khi dial
+	Vhost                    string                // Nếu khác rỗng, thay vhost trong URL của mọi node khi dial
+	MaxChannels              int                   // Số channel tối đa của channel pool trên connection của mỗi node, 0 là dùng channel dùng chung
+	MaxTotalInFlight         int                   // Số client được checkout đồng thời tối đa trên toàn pool, 0 là không giới hạn
+	InFlightPolicy           InFlightPolicy        // Cách xử lý khi số client checkout đạt MaxTotalInFlight
+	PublishMaxAttempts       int                   // Số node tối đa Publish thử khi publish lỗi tạm thời, mặc định 3
+	WarmUpDuration           time.Duration         // Thời gian weight của node vừa phục hồi tăng dần đến đủ (WeightedRoundRobin), 0 là nhận đủ traffic ngay
+	ReconnectConcurrency     int                   // Số lần dial đồng thời tối đa trên toàn pool, 0 là không giới hạn
+	ReconnectJitter          time.Duration         // Độ trễ ngẫu nhiên tối đa trước mỗi lần dial reconnect, 0 là không trễ
+	Backoff                  *BackoffConfig        // Exponential backoff giữa các lần reconnect của client mỗi node, nil là chờ cố định
+	RequireInitialConnection bool                  // Start chờ đến khi có node connect thành công, trả lỗi nếu không node nào connect được
+	InitialConnectTimeout    time.Duration         // Thời gian Start chờ tối đa khi bật RequireInitialConnection, mặc định 30s
+
+	CircuitBreaker         CircuitBreakerConfig        // Circuit breaker cho việc connect lại node đang lỗi
+	MaxConsecutiveFailures int                         // Số lần connect lỗi liên tiếp trước khi node bị đánh dấu failed, 0 là không giới hạn
+	ShouldReconnect        func(err error) bool        // Phân loại lỗi connect, false là lỗi vĩnh viễn (mặc định: ShouldReconnect)
+	OnNodeFailed           func(url string, err error) // Callback khi node bị đánh dấu failed
+
+	dial dialFunc // Hàm dial, thay thế được trong test
+}
+
+// QoSProfile cấu hình QoS cho một channel riêng
+type QoSProfile struct {
+	PrefetchCount int  // Số message chưa ack tối đa
+	PrefetchSize  int  // Tổng kích thước (bytes) chưa ack tối đa
+	Global        bool // Áp dụng QoS cho toàn connection
+}
+
+// ExchangeAutoDeclare cấu hình exchange được tự khai báo trước lần publish đầu tiên
+type ExchangeAutoDeclare struct {
+	Kind       string     // Loại exchange, mặc định "direct"
+	Durable    bool       // Exchange tồn tại qua restart broker
+	AutoDelete bool       // Tự xóa khi không còn binding
+	Args       amqp.Table // Tham số bổ sung cho exchange.declare
+}
+
+// QoSFailurePolicy cách xử lý khi broker từ chối thiết lập QoS cho channel
+type QoSFailurePolicy int
+
+const (
+	QoSFailureFail               QoSFailurePolicy = iota // Trả lỗi, connection/channel bị hủy
+	QoSFailureRetryWithoutGlobal                         // Thử lại với global=false, lỗi nếu vẫn thất bại
+	QoSFailureIgnore                                     // Bỏ qua QoS và tiếp tục dùng channel
+)
+
+// CircuitBreakerConfig cấu hình circuit breaker của mỗi node. Khi breaker mở,
+// pool không connect đến node cho đến hết cooldown, sau đó cho một lần probe
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // Số lần connect lỗi liên tiếp để mở breaker, 0 là tắt
+	Cooldown         time.Duration // Thời gian breaker mở trước lần probe đầu tiên, mặc định theo ReconnectInterval
+	MaxCooldown      time.Duration // Cooldown tối đa (tăng gấp đôi mỗi lần probe lỗi), mặc định 8 lần Cooldown
+}
+
+// BackoffConfig cấu hình exponential backoff giữa các lần reconnect của Client,
+// bắt đầu từ ReconnectInterval
+type BackoffConfig struct {
+	Multiplier  float64       // Hệ số nhân khoảng chờ sau mỗi lần reconnect lỗi, mặc định 2
+	MaxInterval time.Duration // Khoảng chờ tối đa, mặc định 8 lần ReconnectInterval
+	Jitter      bool          // Full jitter: chờ ngẫu nhiên từ 0 đến khoảng chờ đã tính
+}
+
+// CircuitState trạng thái circuit breaker của node
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Connect lại node bình thường
+	CircuitOpen                         // Đang cooldown, không connect đến node
+	CircuitHalfOpen                     // Hết cooldown, đang cho một lần probe
+)
+
+// String trả về tên của trạng thái
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// NodeTier nhóm ưu tiên của node
+type NodeTier int
+
+const (
+	PrimaryTier NodeTier = iota // Node được ưu tiên sử dụng
+	BackupTier                  // Node chỉ dùng khi mọi node primary đều unhealthy
+)
+
+// ConnectionPurpose mục đích sử dụng của connection, quyết định cách cấp channel mặc định
+type ConnectionPurpose int
+
+const (
+	PurposeBoth      ConnectionPurpose = iota // Publish và consume trên cùng connection
+	PurposePublisher                          // Chỉ publish: channel chính không đặt QoS, confirm channel được mở sẵn
+	PurposeConsumer                           // Chỉ consume: channel chính áp dụng prefetch, không mở confirm channel
+)
+
+// String trả về tên của purpose, dùng trong tên connection
+func (p ConnectionPurpose) String() string {
+	switch p {
+	case PurposePublisher:
+		return "publisher"
+	case PurposeConsumer:
+		return "consumer"
+	default:
+		return "both"
+	}
+}
+
+// InFlightPolicy cách xử lý khi số client checkout đạt MaxTotalInFlight
+type InFlightPolicy int
+
+const (
+	InFlightBlock  InFlightPolicy = iota // Chờ đến khi có client được trả lại
+	InFlightReject                       // Trả lỗi ngay
+)
+
+// ConsumerPlacement cách phân bổ consumer lên các node khi Subscribe
+type ConsumerPlacement int
+
+const (
+	PlacementSingle  ConsumerPlacement = iota // Một consumer trên một node chọn theo load balancing
+	PlacementAll                              // Một consumer trên mỗi node healthy
+	PlacementPerNode                          // Một consumer trên mỗi node của tối đa NodeCount node healthy
+)
+
+// SubscribeOptions tùy chọn cho Pool.Subscribe
+type SubscribeOptions struct {
+	ConsumeOptions
+	Placement ConsumerPlacement // Cách phân bổ consumer, mặc định PlacementSingle
+	NodeCount int               // Số node dùng cho PlacementPerNode
 }
 
 // LoadBalanceStrategy chiến lược load balancing
@@ -24,8 +193,33 @@ const (
 	Random
 	LeastUsed
 	WeightedRoundRobin
+	LeastFailures    // Node healthy có ít lỗi nhất trong FailureWindow
+	ConsistentHash   // Cùng key luôn về cùng node (GetClientForKey), GetClient không có key dùng round robin
+	LeastConnections // Node healthy có ít client đang checkout và publish đang chạy nhất
 )
 
+// String trả về tên strategy
+func (lb LoadBalanceStrategy) String() string {
+	switch lb {
+	case RoundRobin:
+		return "RoundRobin"
+	case Random:
+		return "Random"
+	case LeastUsed:
+		return "LeastUsed"
+	case WeightedRoundRobin:
+		return "WeightedRoundRobin"
+	case LeastFailures:
+		return "LeastFailures"
+	case ConsistentHash:
+		return "ConsistentHash"
+	case LeastConnections:
+		return "LeastConnections"
+	default:
+		return "Unknown"
+	}
+}
+
 // Logger interface để log các sự kiện
 type Logger interface {
 	Debug(msg string, args ...interface{})
@@ -36,15 +230,54 @@ type Logger interface {
 
 // NodeConnection thông tin kết nối đến một node (chỉ 1 connection per node)
 type NodeConnection struct {
-	URL        string
-	Client     *Client
-	mutex      sync.RWMutex
-	healthy    bool
-	weight     int
-	lastUsed   time.Time
-	totalUsed  int64
-	failures   int64
-	connecting bool
+	ID                  string // ID ổn định của node, không phụ thuộc credentials
+	URL                 string
+	Client              *Client
+	mutex               sync.RWMutex
+	healthy             bool
+	weight              int
+	tier                NodeTier
+	cordoned            bool // Bị cordon qua SetNodeCordoned
+	checkCordoned       bool // Bị cordon theo kết quả CordonCheck gần nhất
+	currentWeight       int  // Trạng thái smooth weighted round robin, bảo vệ bởi Pool.wrrMutex
+	lastUsed            time.Time
+	totalUsed           int64 // Truy cập atomic
+	failures            int64 // Truy cập atomic
+	removed             bool  // Node đã bị xóa khỏi pool
+	failed              bool  // Node đã vượt giới hạn lỗi liên tiếp, ngừng reconnect
+	consecutiveFailures int
+	attempted           bool           // Lần connect đầu tiên đã kết thúc
+	firstErr            error          // Lỗi của lần connect đầu tiên, nil nếu thành công
+	downSince           time.Time      // Thời điểm node chuyển sang unhealthy, zero khi đang healthy
+	downtime            time.Duration  // Tổng thời gian unhealthy của các lần lỗi đã phục hồi
+	recoveredAt         time.Time      // Thời điểm node phục hồi gần nhất, dùng cho warm-up
+	connectLog          logThrottle    // Chặn log lặp lại của cùng một lỗi connect
+	connecting          int32          // 1 khi đang có một lần connect chạy (truy cập atomic)
+	probing             int32          // 1 khi health check của node đang chờ hoặc đang chạy (truy cập atomic)
+	dialed              int32          // 1 sau lần dial đầu tiên của node (truy cập atomic)
+	publishAttempts     int64          // Số lần PublishReliable publish trên node (truy cập atomic)
+	publishFailures     int64          // Số lần Publish lỗi trên node (truy cập atomic)
+	inFlight            int64          // Số client đang checkout qua AcquireClient và publish đang chạy trên node (truy cập atomic)
+	checkoutLatency     ewma           // Thời gian giữ client từ AcquireClient đến lúc release
+	latency             latencyWindow  // Latency của các operation gần nhất: checkout của AcquireClient và mỗi lần publish của Publish/PublishReliable
+	closeCodes          closeCodeTally // Số lần connection/channel bị broker đóng theo mã lỗi
+	recentFailures      windowCounter  // Lỗi connect, health check và publish trong FailureWindow
+	circuit             CircuitState   // Trạng thái circuit breaker
+	circuitCooldown     time.Duration  // Cooldown của lần mở breaker gần nhất
+	circuitUntil        time.Time      // Thời điểm breaker đang mở cho phép probe
+
+	probeInterval time.Duration // Khoảng health check hiện tại của node
+	nextProbe     time.Time     // Thời điểm sớm nhất cho lần health check tiếp theo
+}
+
+// ConnectionInfo thông số đã thỏa thuận của connection, chỉ dùng để chẩn đoán
+type ConnectionInfo struct {
+	FrameMax         int           `json:"frame_max"`
+	ChannelMax       int           `json:"channel_max"`
+	Heartbeat        time.Duration `json:"heartbeat"`
+	Vhost            string        `json:"vhost"`
+	Locale           string        `json:"locale"`
+	ServerProperties amqp.Table    `json:"server_properties"`
 }
 
 // PoolStats thống kê của pool
@@ -53,16 +286,43 @@ type PoolStats struct {
 	HealthyNodes  int         `json:"healthy_nodes"`
 	TotalRequests int64       `json:"total_requests"`
 	TotalFailures int64       `json:"total_failures"`
+	InFlight      int64       `json:"in_flight"` // Số client đang được checkout
 	NodesStats    []NodeStats `json:"nodes_stats"`
 }
 
+// NodeHealthEvent được gửi qua Pool.HealthEvents khi node đổi trạng thái healthy
+type NodeHealthEvent struct {
+	NodeID  string    // ID của node
+	URL     string    // URL của node, đã ẩn credentials trừ khi ShowCredentials
+	Healthy bool      // Trạng thái mới
+	Time    time.Time // Thời điểm chuyển trạng thái
+}
+
 // NodeStats thống kê của một node
 type NodeStats struct {
-	URL       string `json:"url"`
-	Healthy   bool   `json:"healthy"`
-	Connected bool   `json:"connected"`
-	TotalUsed int64  `json:"total_used"`
-	Failures  int64  `json:"failures"`
-	Weight    int    `json:"weight"`
-	LastUsed  string `json:"last_used"`
+	NodeID        string        `json:"node_id"`
+	URL           string        `json:"url"`
+	Healthy       bool          `json:"healthy"`
+	Connected     bool          `json:"connected"`
+	TotalUsed     int64         `json:"total_used"`
+	ActualShare   float64       `json:"actual_share"` // TotalUsed chia cho tổng TotalUsed của pool
+	Failures      int64         `json:"failures"`
+	Weight        int           `json:"weight"`
+	Failed        bool          `json:"failed"`
+	Tier          NodeTier      `json:"tier"`
+	Cordoned      bool          `json:"cordoned"`
+	LastUsed      string        `json:"last_used"`
+	Heartbeat     time.Duration `json:"heartbeat"`
+	TotalDowntime time.Duration `json:"total_downtime"` // Tổng thời gian unhealthy kể từ lần healthy đầu tiên
+
+	ConfirmLatency  time.Duration `json:"confirm_latency"`   // EWMA thời gian từ publish đến ack
+	PublishAttempts int64         `json:"publish_attempts"`  // Số lần PublishReliable publish trên node
+	PublishFailures int64         `json:"publish_failures"`  // Số lần Publish lỗi trên node
+	InFlight        int64         `json:"in_flight"`         // Số client đang checkout và publish đang chạy trên node
+	CheckoutLatency time.Duration `json:"checkout_latency"`  // EWMA thời gian giữ client từ AcquireClient đến lúc release
+	AvgLatencyMs    float64       `json:"avg_latency_ms"`    // Latency trung bình (ms) của các operation gần nhất trên node
+	P99LatencyMs    float64       `json:"p99_latency_ms"`    // Latency p99 (ms) của các operation gần nhất trên node
+	CloseErrorCodes map[int]int64 `json:"close_error_codes"` // Số lần connection/channel bị broker đóng theo mã lỗi AMQP
+	RecentFailures  int64         `json:"recent_failures"`   // Lỗi connect, health check và publish trong FailureWindow
+	Circuit         CircuitState  `json:"circuit"`           // Trạng thái circuit breaker của node
 }
@@ -1,7 +1,18 @@
 package bunnyhop
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 func getDefaultConfig(config *PoolConfig) {
@@ -12,13 +23,349 @@ func getDefaultConfig(config *PoolConfig) {
 	if config.ReconnectInterval == 0 {
 		config.ReconnectInterval = 30 * time.Second
 	}
+	if config.MinReconnectInterval <= 0 {
+		config.MinReconnectInterval = defaultMinReconnectInterval
+	}
 	if config.HealthCheckInterval == 0 {
 		config.HealthCheckInterval = 30 * time.Second
 	}
-	if len(config.URLs) == 0 {
-		config.URLs = []string{"amqp://localhost:5672"}
+	if config.HealthCheckMaxInterval < config.HealthCheckInterval {
+		config.HealthCheckMaxInterval = 8 * config.HealthCheckInterval
+	}
+	if config.HealthCheckTimeout <= 0 {
+		config.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if config.ReconnectLogInterval == 0 {
+		config.ReconnectLogInterval = defaultReconnectLogInterval
+	}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
+	if config.InitialConnectTimeout <= 0 {
+		config.InitialConnectTimeout = defaultInitialConnectTimeout
+	}
+	if config.FailureWindow <= 0 {
+		config.FailureWindow = defaultFailureWindow
+	}
+	if config.CircuitBreaker.FailureThreshold > 0 {
+		if config.CircuitBreaker.Cooldown <= 0 {
+			config.CircuitBreaker.Cooldown = reconnectDelay(config.ReconnectInterval, config.MinReconnectInterval)
+		}
+		if config.CircuitBreaker.MaxCooldown < config.CircuitBreaker.Cooldown {
+			config.CircuitBreaker.MaxCooldown = 8 * config.CircuitBreaker.Cooldown
+		}
+	}
+	if config.PublishMaxAttempts <= 0 {
+		config.PublishMaxAttempts = defaultPublishMaxAttempts
 	}
 	if config.Logger == nil {
 		config.Logger = NewDefaultLogger(config.DebugLog)
 	}
+	if config.ShouldReconnect == nil {
+		config.ShouldReconnect = ShouldReconnect
+	}
+}
+
+// mergeHeaders gộp headers mặc định vào headers của message,
+// key do caller đặt được ưu tiên
+func mergeHeaders(defaults, headers amqp.Table) amqp.Table {
+	if len(defaults) == 0 {
+		return headers
+	}
+
+	merged := make(amqp.Table, len(defaults)+len(headers))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return merged
+}
+
+// newUUID sinh UUID phiên bản 4 ngẫu nhiên
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant RFC 4122
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// defaultNodeID sinh ID node từ host:port/vhost của URL, bỏ qua credentials
+// để ID không đổi khi đổi mật khẩu
+func defaultNodeID(url string) string {
+	key := url
+	if uri, err := amqp.ParseURI(url); err == nil {
+		key = fmt.Sprintf("%s:%d/%s", uri.Host, uri.Port, uri.Vhost)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// redactURL thay userinfo trong URL bằng "****", ví dụ amqp://****@host:5672/
+func redactURL(url string) string {
+	scheme := strings.Index(url, "://")
+	if scheme < 0 {
+		return url
+	}
+	rest := url[scheme+3:]
+	if slash := strings.IndexAny(rest, "/?#"); slash >= 0 {
+		rest = rest[:slash]
+	}
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return url
+	}
+	return url[:scheme+3] + "****" + url[scheme+3+at:]
+}
+
+// withCredentials trả về url với username, password và vhost được thay bằng
+// giá trị khác rỗng truyền vào. Query param của url được giữ nguyên
+func withCredentials(url, username, password, vhost string) (string, error) {
+	uri, err := amqp.ParseURI(url)
+	if err != nil {
+		return "", urlError(url, err)
+	}
+	if username != "" {
+		uri.Username = username
+	}
+	if password != "" {
+		uri.Password = password
+	}
+	if vhost != "" {
+		uri.Vhost = vhost
+	}
+
+	// URI.String chỉ giữ các query param TLS, khôi phục query gốc (heartbeat, weight...)
+	result, err := neturl.Parse(uri.String())
+	if err != nil {
+		return "", urlError(url, err)
+	}
+	if original, err := neturl.Parse(url); err == nil {
+		result.RawQuery = original.RawQuery
+	}
+	return result.String(), nil
+}
+
+// urlError tạo lỗi parse URL không chứa credentials. Lỗi của net/url chứa
+// nguyên URL nên chỉ giữ lại nguyên nhân
+func urlError(url string, err error) error {
+	var parseErr *neturl.Error
+	if errors.As(err, &parseErr) {
+		err = parseErr.Err
+	}
+	return fmt.Errorf("invalid URL %s: %v", redactURL(url), err)
+}
+
+// urlOptions các tùy chọn đọc từ query param của URL node, giá trị 0 là không có
+type urlOptions struct {
+	Heartbeat         time.Duration // heartbeat (giây)
+	ConnectionTimeout time.Duration // connection_timeout (mili giây)
+	Weight            int           // weight, chỉ dùng cho Pool
+}
+
+// parseURLOptions đọc các query param được hỗ trợ từ URL:
+// heartbeat (giây), connection_timeout (mili giây) và weight
+func parseURLOptions(url string) (urlOptions, error) {
+	var opts urlOptions
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return opts, urlError(url, err)
+	}
+	params := u.Query()
+
+	if params.Has("heartbeat") {
+		seconds, err := strconv.Atoi(params.Get("heartbeat"))
+		if err != nil || seconds < 0 {
+			return opts, fmt.Errorf("invalid heartbeat %q", params.Get("heartbeat"))
+		}
+		opts.Heartbeat = time.Duration(seconds) * time.Second
+	}
+	if params.Has("connection_timeout") {
+		millis, err := strconv.Atoi(params.Get("connection_timeout"))
+		if err != nil || millis < 0 {
+			return opts, fmt.Errorf("invalid connection_timeout %q", params.Get("connection_timeout"))
+		}
+		opts.ConnectionTimeout = time.Duration(millis) * time.Millisecond
+	}
+	if params.Has("weight") {
+		weight, err := strconv.Atoi(params.Get("weight"))
+		if err != nil || weight < 0 {
+			return opts, fmt.Errorf("invalid weight %q", params.Get("weight"))
+		}
+		opts.Weight = weight
+	}
+
+	return opts, nil
+}
+
+// defaultMinReconnectInterval khoảng chờ reconnect tối thiểu mặc định
+const defaultMinReconnectInterval = 100 * time.Millisecond
+
+// reconnectDelay trả về khoảng chờ reconnect, không nhỏ hơn floor để tránh
+// reconnect liên tục khi ReconnectInterval cấu hình quá nhỏ
+func reconnectDelay(interval, floor time.Duration) time.Duration {
+	if interval < floor {
+		return floor
+	}
+	return interval
+}
+
+// defaultPoolURL URL node của pool khi PoolConfig.URLs rỗng
+const defaultPoolURL = "amqp://localhost:5672"
+
+// defaultPublishMaxAttempts số node mặc định Pool.Publish thử cho một message
+const defaultPublishMaxAttempts = 3
+
+// defaultHealthCheckTimeout thời gian chờ mặc định của ping trong ActiveHealthCheck
+const defaultHealthCheckTimeout = 5 * time.Second
+
+// defaultDrainTimeout thời gian mặc định Close chờ handler của consumer xử lý xong
+const defaultDrainTimeout = 30 * time.Second
+
+// defaultInitialConnectTimeout thời gian Start chờ node đầu tiên connect khi bật RequireInitialConnection
+const defaultInitialConnectTimeout = 30 * time.Second
+
+// defaultFailureWindow cửa sổ đếm lỗi gần đây mặc định của node
+const defaultFailureWindow = time.Minute
+
+// windowBuckets số bucket của windowCounter
+const windowBuckets = 10
+
+// windowCounter đếm sự kiện trong cửa sổ trượt, chia thành windowBuckets
+// bucket bằng nhau nên sự kiện cũ hết hạn theo từng bucket
+type windowCounter struct {
+	mutex  sync.Mutex
+	counts [windowBuckets]int64
+	epochs [windowBuckets]int64 // Chỉ số bucket tuyệt đối mà counts[i] đang đếm
+}
+
+// epoch trả về chỉ số bucket tuyệt đối của thời điểm now
+func windowEpoch(now time.Time, window time.Duration) int64 {
+	width := int64(window / windowBuckets)
+	if width <= 0 {
+		width = 1
+	}
+	return now.UnixNano() / width
+}
+
+// add ghi nhận một sự kiện tại now
+func (w *windowCounter) add(now time.Time, window time.Duration) {
+	epoch := windowEpoch(now, window)
+	i := epoch % windowBuckets
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.epochs[i] != epoch {
+		w.epochs[i] = epoch
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// count trả về số sự kiện trong cửa sổ kết thúc tại now
+func (w *windowCounter) count(now time.Time, window time.Duration) int64 {
+	epoch := windowEpoch(now, window)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var total int64
+	for i := range w.counts {
+		if epoch-w.epochs[i] < windowBuckets {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// broadcast đánh thức mọi goroutine đang chờ mỗi khi notify được gọi
+type broadcast struct {
+	mutex sync.Mutex
+	ch    chan struct{}
+}
+
+// wait trả về channel được đóng ở lần notify tiếp theo
+func (b *broadcast) wait() <-chan struct{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan struct{})
+	}
+	return b.ch
+}
+
+// notify đánh thức các goroutine đang chờ
+func (b *broadcast) notify() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.ch != nil {
+		close(b.ch)
+		b.ch = nil
+	}
+}
+
+// hashRingReplicas số điểm ảo của mỗi node trên hash ring, đủ để key phân bổ đều
+const hashRingReplicas = 128
+
+// hashRing ánh xạ key sang node bằng consistent hashing trên ID của node
+type hashRing struct {
+	nodes  []*NodeConnection // Tập node dựng ring, theo thứ tự của getHealthyNodes
+	points []uint32          // Hash của các điểm ảo, đã sắp xếp
+	owners map[uint32]*NodeConnection
+}
+
+// newHashRing dựng hash ring từ danh sách node
+func newHashRing(nodes []*NodeConnection) *hashRing {
+	r := &hashRing{
+		nodes:  append([]*NodeConnection(nil), nodes...),
+		points: make([]uint32, 0, len(nodes)*hashRingReplicas),
+		owners: make(map[uint32]*NodeConnection, len(nodes)*hashRingReplicas),
+	}
+	for _, node := range nodes {
+		for i := 0; i < hashRingReplicas; i++ {
+			point := hashKey(node.ID + "#" + strconv.Itoa(i))
+			if _, exists := r.owners[point]; exists {
+				continue
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// matches cho biết ring có được dựng từ đúng tập node này không
+func (r *hashRing) matches(nodes []*NodeConnection) bool {
+	if len(r.nodes) != len(nodes) {
+		return false
+	}
+	for i, node := range nodes {
+		if r.nodes[i] != node {
+			return false
+		}
+	}
+	return true
+}
+
+// get trả về node sở hữu điểm đầu tiên từ hash của key theo chiều kim đồng hồ
+func (r *hashRing) get(key string) *NodeConnection {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hashKey băm chuỗi bằng FNV-1a 32 bit
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
 }
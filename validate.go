@@ -0,0 +1,189 @@
+package bunnyhop
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// durationField một trường thời gian cần kiểm tra không âm
+type durationField struct {
+	name  string
+	value time.Duration
+}
+
+// intField một trường số nguyên cần kiểm tra không âm
+type intField struct {
+	name  string
+	value int
+}
+
+// Validate kiểm tra cấu hình client: URL rỗng, không đúng định dạng AMQP hoặc
+// bị trùng, thời gian và số lượng âm. Giá trị 0 hợp lệ và được thay bằng mặc
+// định trong NewClient. NewClient bỏ qua URL không hợp lệ và chỉ log các lỗi
+// khác, trừ lỗi của connectError được trả về khi connect.
+func (c Config) Validate() error {
+	if err := validateURLs(c.URLs, false); err != nil {
+		return err
+	}
+	if err := c.connectError(); err != nil {
+		return err
+	}
+	if err := validateDurations(
+		durationField{"reconnect interval", c.ReconnectInterval},
+		durationField{"min reconnect interval", c.MinReconnectInterval},
+		durationField{"drain timeout", c.DrainTimeout},
+		durationField{"reconnect log interval", c.ReconnectLogInterval},
+		durationField{"keepalive", c.Keepalive},
+	); err != nil {
+		return err
+	}
+	if err := validateInts(intField{"max reconnect attempt", c.MaxReconnectAttempt}); err != nil {
+		return err
+	}
+	return c.Backoff.validate()
+}
+
+// connectError kiểm tra các giá trị làm Connect thất bại (prefetch, heartbeat,
+// dial timeout và max channels âm), NewClient giữ lỗi này để trả về khi connect
+func (c Config) connectError() error {
+	if c.PrefetchCount < 0 || c.PrefetchSize < 0 {
+		return fmt.Errorf("invalid QoS: prefetch count %d and prefetch size %d must not be negative",
+			c.PrefetchCount, c.PrefetchSize)
+	}
+	if err := validateDurations(
+		durationField{"heartbeat", c.Heartbeat},
+		durationField{"dial timeout", c.DialTimeout},
+	); err != nil {
+		return err
+	}
+	return validateInts(intField{"max channels", c.MaxChannels})
+}
+
+// Validate kiểm tra cấu hình pool như Config.Validate, thêm query param của
+// URL node (weight, heartbeat, connection_timeout) phải hợp lệ. NewPool bỏ qua
+// URL không hợp lệ và chỉ log các lỗi khác, dùng NewPoolWithError để nhận lỗi.
+func (c PoolConfig) Validate() error {
+	if err := validateURLs(c.URLs, true); err != nil {
+		return err
+	}
+	if c.PrefetchCount < 0 || c.PrefetchSize < 0 {
+		return fmt.Errorf("invalid QoS: prefetch count %d and prefetch size %d must not be negative",
+			c.PrefetchCount, c.PrefetchSize)
+	}
+	if err := validateDurations(
+		durationField{"reconnect interval", c.ReconnectInterval},
+		durationField{"min reconnect interval", c.MinReconnectInterval},
+		durationField{"health check interval", c.HealthCheckInterval},
+		durationField{"health check max interval", c.HealthCheckMaxInterval},
+		durationField{"health check timeout", c.HealthCheckTimeout},
+		durationField{"drain timeout", c.DrainTimeout},
+		durationField{"close timeout", c.CloseTimeout},
+		durationField{"failure window", c.FailureWindow},
+		durationField{"reconnect log interval", c.ReconnectLogInterval},
+		durationField{"keepalive", c.Keepalive},
+		durationField{"heartbeat", c.Heartbeat},
+		durationField{"dial timeout", c.DialTimeout},
+		durationField{"warm up duration", c.WarmUpDuration},
+		durationField{"reconnect jitter", c.ReconnectJitter},
+		durationField{"initial connect timeout", c.InitialConnectTimeout},
+		durationField{"circuit breaker cooldown", c.CircuitBreaker.Cooldown},
+		durationField{"circuit breaker max cooldown", c.CircuitBreaker.MaxCooldown},
+	); err != nil {
+		return err
+	}
+	if err := validateInts(
+		intField{"max reconnect attempt", c.MaxReconnectAttempt},
+		intField{"health check concurrency", c.HealthCheckConcurrency},
+		intField{"max channels", c.MaxChannels},
+		intField{"max total in flight", c.MaxTotalInFlight},
+		intField{"publish max attempts", c.PublishMaxAttempts},
+		intField{"reconnect concurrency", c.ReconnectConcurrency},
+		intField{"max consecutive failures", c.MaxConsecutiveFailures},
+		intField{"circuit breaker failure threshold", c.CircuitBreaker.FailureThreshold},
+	); err != nil {
+		return err
+	}
+	return c.Backoff.validate()
+}
+
+// validate kiểm tra backoff, nil là hợp lệ
+func (b *BackoffConfig) validate() error {
+	if b == nil {
+		return nil
+	}
+	if b.Multiplier != 0 && b.Multiplier < 1 {
+		return fmt.Errorf("invalid backoff multiplier %v: must be at least 1", b.Multiplier)
+	}
+	return validateDurations(durationField{"backoff max interval", b.MaxInterval})
+}
+
+// validateURLs kiểm tra mỗi URL khác rỗng, đúng định dạng AMQP và không bị
+// trùng. withOptions kiểm tra thêm query param của node trong pool. Lỗi chỉ
+// chứa URL đã ẩn credentials.
+func validateURLs(urls []string, withOptions bool) error {
+	seen := make(map[string]bool, len(urls))
+	for i, url := range urls {
+		if err := checkURL(i, url, withOptions, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usableURLs trả về các URL qua được kiểm tra của validateURLs (không xét query
+// param, vốn đã được bỏ qua khi lỗi), kèm lỗi của từng URL bị loại
+func usableURLs(urls []string) ([]string, []error) {
+	seen := make(map[string]bool, len(urls))
+	usable := make([]string, 0, len(urls))
+	var errs []error
+	for i, url := range urls {
+		if err := checkURL(i, url, false, seen); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		usable = append(usable, url)
+	}
+	return usable, errs
+}
+
+// checkURL kiểm tra URL thứ i, URL hợp lệ được đánh dấu trong seen
+func checkURL(i int, url string, withOptions bool, seen map[string]bool) error {
+	if url == "" {
+		return fmt.Errorf("invalid URL at index %d: must not be empty", i)
+	}
+	if _, err := amqp.ParseURI(url); err != nil {
+		return urlError(url, err)
+	}
+	if withOptions {
+		if _, err := parseURLOptions(url); err != nil {
+			return fmt.Errorf("invalid URL %s: %v", redactURL(url), err)
+		}
+	}
+	if seen[url] {
+		return fmt.Errorf("duplicate URL %s", redactURL(url))
+	}
+	seen[url] = true
+	return nil
+}
+
+// validateDurations trả về lỗi cho trường thời gian âm đầu tiên
+func validateDurations(fields ...durationField) error {
+	for _, f := range fields {
+		if f.value < 0 {
+			return fmt.Errorf("invalid %s %v: must not be negative", f.name, f.value)
+		}
+	}
+	return nil
+}
+
+// validateInts trả về lỗi cho trường số nguyên âm đầu tiên
+func validateInts(fields ...intField) error {
+	for _, f := range fields {
+		if f.value < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", f.name, f.value)
+		}
+	}
+	return nil
+}